@@ -0,0 +1,482 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+)
+
+// The AI places each piece by trying every placement it can reach (see
+// Placements) and scoring the board each leaves: a weighted sum of
+// features such as height and holes, plus rewards for the lines it
+// clears. It looks ahead through the preview and hold, keeping only the
+// best few boards at each piece (a beam search), and plays the first
+// piece of the best line it finds
+
+// AIWeights weigh the features of a board and the rewards for clears
+// when the AI scores a placement. Features that make a board worse have
+// negative weights
+type AIWeights struct {
+	Height         float64    `json:"height"`          // Per cell of column height, over all columns
+	Holes          float64    `json:"holes"`           // Per empty cell with a filled cell above it
+	Bumpiness      float64    `json:"bumpiness"`       // Per cell of height difference between neighboring columns, but the lowest
+	Wells          float64    `json:"wells"`           // Per cell of wells but the lowest column, deeper cells counting more (1+2+...)
+	RowTransitions float64    `json:"row_transitions"` // Per filled cell next to an empty one in a row, walls filled
+	ColTransitions float64    `json:"col_transitions"` // Per filled cell above or below an empty one, floor filled
+	TSlots         float64    `json:"t_slots"`         // Per slot a T can spin into for a full T-spin
+	Clears         [5]float64 `json:"clears"`          // Reward for clearing 0 to 4 lines at once
+	TSpin          float64    `json:"t_spin"`          // Reward per line cleared by a full T-spin
+}
+
+// DefaultAIWeights are weights tuned by playing Marathon headless with
+// the ai subcommand
+func DefaultAIWeights() AIWeights {
+	return AIWeights{
+		Height:         -0.1,
+		Holes:          -4,
+		Bumpiness:      -0.3,
+		Wells:          -1,
+		RowTransitions: -0.6,
+		ColTransitions: -1.5,
+		TSlots:         1.5,
+		Clears:         [5]float64{0, -8, -7, -6, 16},
+		TSpin:          4,
+	}
+}
+
+// LoadAIWeights reads weights from a JSON file. Weights the file leaves
+// out keep their defaults
+func LoadAIWeights(path string) (AIWeights, error) {
+	weights := DefaultAIWeights()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return weights, err
+	}
+	if err := json.Unmarshal(data, &weights); err != nil {
+		return weights, err
+	}
+	return weights, nil
+}
+
+// loadAIWeights reads the weights in path, or if path is empty those in
+// ai.json in the gotetris data directory, which is optional
+func loadAIWeights(path string) (AIWeights, error) {
+	if path != "" {
+		return LoadAIWeights(path)
+	}
+	path, err := dataPath("ai.json")
+	if err != nil {
+		return DefaultAIWeights(), nil
+	}
+	weights, err := LoadAIWeights(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return weights, nil
+	}
+	return weights, err
+}
+
+// AI chooses placements for a game's pieces
+type AI struct {
+	Weights   AIWeights
+	Lookahead int // Preview pieces looked at after the current one
+	Beam      int // Boards kept at each piece of the lookahead
+}
+
+// Default AI search settings
+const (
+	DefaultAILookahead = 1
+	DefaultAIBeam      = 6
+)
+
+// NewAI creates an AI with the given weights and the default lookahead
+func NewAI(weights AIWeights) *AI {
+	return &AI{Weights: weights, Lookahead: DefaultAILookahead, Beam: DefaultAIBeam}
+}
+
+// AIMove is the AI's choice for the current piece: a placement, made
+// after holding if Hold is set
+type AIMove struct {
+	Hold      bool
+	Placement Placement // For the piece in play after the hold, if any
+}
+
+// aiNode is a board in the AI's lookahead, with the pieces left to play
+// on it and the move for the current piece that led there
+type aiNode struct {
+	board      Board
+	current    PieceType // Piece to place next, if hasCurrent
+	hasCurrent bool
+	hold       PieceType
+	hasHold    bool
+	canHold    bool // Hold may be used for current
+	next       int  // Index of the next preview piece to come
+	reward     float64
+	value      float64 // reward plus the board's score
+	move       AIMove
+}
+
+// Choose returns the AI's move for the game's current piece, or false
+// if there is no piece in play or nowhere to put it
+// The current piece is moved from where it is, so the move's path
+// starts there; a piece swapped in by hold is moved from its spawn
+func (ai *AI) Choose(g *Game) (AIMove, bool) {
+	if g.Current == nil {
+		return AIMove{}, false
+	}
+	queue := g.Queue[:min(ai.Lookahead+1, len(g.Queue))]
+	root := aiNode{
+		board:      *g.Board,
+		current:    g.Current.Type,
+		hasCurrent: true,
+		hold:       g.Hold,
+		hasHold:    g.HasHold,
+		canHold:    !g.HoldUsed && !g.Mode.NoHold,
+	}
+
+	var best *aiNode
+	beam := []*aiNode{&root}
+	for depth := 0; depth <= ai.Lookahead && len(beam) > 0; depth++ {
+		var children []*aiNode
+		for _, n := range beam {
+			children = append(children, ai.expand(g, n, queue, depth == 0)...)
+		}
+		if len(children) == 0 {
+			break
+		}
+		slices.SortStableFunc(children, func(a, b *aiNode) int {
+			return cmp.Compare(b.value, a.value)
+		})
+		best = children[0]
+		beam = children[:min(len(children), max(ai.Beam, 1))]
+	}
+	if best == nil {
+		return AIMove{}, false
+	}
+	return best.move, true
+}
+
+// expand returns the boards each placement of the node's current piece
+// leads to, and those of the piece hold would swap in. At the root the
+// current piece is the one in play, moved from where it is
+func (ai *AI) expand(g *Game, n *aiNode, queue []PieceType, root bool) []*aiNode {
+	if !n.hasCurrent {
+		return nil
+	}
+	var children []*aiNode
+	place := func(n *aiNode, start *Piece, held bool) {
+		searchPlacements(&n.board, start, g.Mode.rotates180(), func(p Placement) bool {
+			child := *n
+			if !child.board.Lock(&p.Piece) {
+				// Locking above the top row ends the game
+				return true
+			}
+			lines := child.board.ClearLines()
+			child.reward += ai.Weights.Clears[min(lines, 4)]
+			if p.Spin == SpinFull {
+				child.reward += ai.Weights.TSpin * float64(lines)
+			}
+			child.value = child.reward + ai.Weights.score(&child.board)
+			child.hasCurrent = child.next < len(queue)
+			if child.hasCurrent {
+				child.current = queue[child.next]
+				child.next++
+			}
+			child.canHold = !g.Mode.NoHold
+			if root {
+				child.move = AIMove{Hold: held, Placement: p}
+			}
+			children = append(children, &child)
+			return true
+		})
+	}
+
+	start := g.Mode.spawnPiece(n.current)
+	if root {
+		start = g.Current
+	}
+	place(n, start, false)
+
+	if !n.canHold {
+		return children
+	}
+	// Hold the current piece and place the one in hold, or the next
+	// one in the preview if hold is empty
+	held := *n
+	held.hold, held.hasHold = n.current, true
+	switch {
+	case n.hasHold:
+		held.current = n.hold
+	case n.next < len(queue):
+		held.current = queue[n.next]
+		held.next++
+	default:
+		return children
+	}
+	if held.current != n.current {
+		place(&held, g.Mode.spawnPiece(held.current), true)
+	}
+	return children
+}
+
+// score returns the weighted sum of the board's features
+func (w AIWeights) score(b *Board) float64 {
+	f := boardFeatures(b)
+	return w.Height*float64(f.height) +
+		w.Holes*float64(f.holes) +
+		w.Bumpiness*float64(f.bumpiness) +
+		w.Wells*float64(f.wells) +
+		w.RowTransitions*float64(f.rowTransitions) +
+		w.ColTransitions*float64(f.colTransitions) +
+		w.TSlots*float64(f.tSlots)
+}
+
+// aiFeatures are the features of a board the AI weighs
+type aiFeatures struct {
+	height         int
+	holes          int
+	bumpiness      int
+	wells          int
+	rowTransitions int
+	colTransitions int
+	tSlots         int
+}
+
+// boardFeatures measures a board for the AI. The lowest column is left
+// out of bumpiness and wells: it's the well kept open for tetrises
+func boardFeatures(b *Board) aiFeatures {
+	var f aiFeatures
+	filled := func(row, col int) bool {
+		return b.blocked(row, col)
+	}
+
+	var heights [BoardWidth]int
+	top, well := BoardHeight, 0
+	for col := range BoardWidth {
+		row := 0
+		for row < BoardHeight && !b.Cells[row][col].Filled {
+			row++
+		}
+		heights[col] = BoardHeight - row
+		top = min(top, row)
+		f.height += heights[col]
+		if heights[col] < heights[well] {
+			well = col
+		}
+
+		for ; row < BoardHeight; row++ {
+			if !b.Cells[row][col].Filled {
+				f.holes++
+			}
+			if filled(row, col) != filled(row+1, col) {
+				f.colTransitions++
+			}
+		}
+	}
+
+	for col := range BoardWidth {
+		if col > 0 && col != well && col-1 != well {
+			f.bumpiness += abs(heights[col] - heights[col-1])
+		}
+		if col == well {
+			continue
+		}
+		// Wells: empty cells with both neighbors filled, counted from
+		// the top of each so deep wells cost more
+		depth := 0
+		for row := 0; row < BoardHeight; row++ {
+			if filled(row, col) {
+				depth = 0
+				continue
+			}
+			if filled(row, col-1) && filled(row, col+1) {
+				depth++
+				f.wells += depth
+			}
+		}
+	}
+
+	for row := top; row < BoardHeight; row++ {
+		for col := -1; col < BoardWidth; col++ {
+			if filled(row, col) != filled(row, col+1) {
+				f.rowTransitions++
+			}
+		}
+	}
+	f.tSlots = countTSlots(b, top)
+	return f
+}
+
+// countTSlots counts the places a T pointing down fits, can't fall
+// from and would make a full T-spin in, from the top row of the stack
+// down. Whether the T can be moved there isn't checked
+func countTSlots(b *Board, top int) int {
+	slots := 0
+	for row := max(top-2, -1); row < BoardHeight; row++ {
+		for col := -1; col < BoardWidth; col++ {
+			t := Piece{Type: PieceT, Rotation: Rotation2, Row: row, Col: col}
+			below := t
+			below.Row++
+			if b.Collides(&t) || !b.Collides(&below) {
+				continue
+			}
+			if spinOf(b, &t, true, 0) == SpinFull {
+				slots++
+			}
+		}
+	}
+	return slots
+}
+
+// abs returns the absolute value of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Bot chooses the moves for a game's pieces: the built-in AI, or an
+// outside one (see TBPBot)
+type Bot interface {
+	// Choose returns the move for the game's current piece, or false if
+	// there is none, see AI.Choose
+	Choose(g *Game) (AIMove, bool)
+}
+
+// AIPlayer plays a game with a bot an input at a time, so it can be
+// watched. It keeps to the placement chosen for a piece, finding a new
+// way there if something else moves the piece, such as gravity
+type AIPlayer struct {
+	Bot Bot
+
+	target *Placement // Placement the current piece is moved to, nil to choose one
+	path   []Action   // Inputs left to get there
+	piece  Piece      // Where the current piece was after the last input
+	pieces int        // Game.Pieces when the target was chosen
+}
+
+// NewAIPlayer creates a player for a bot
+func NewAIPlayer(bot Bot) *AIPlayer {
+	return &AIPlayer{Bot: bot}
+}
+
+// Target returns the placement the AI is moving the game's current
+// piece to, or nil if it hasn't chosen one
+func (p *AIPlayer) Target(g *Game) *Piece {
+	if p.target == nil || g.Current == nil || g.Pieces != p.pieces {
+		return nil
+	}
+	return &p.target.Piece
+}
+
+// Play gives the game the next input for its current piece, returning
+// false if there is nothing to do yet: no piece in play, or one waiting
+// to lock in a mode without hard drop
+func (p *AIPlayer) Play(g *Game) bool {
+	if g.Current == nil || g.Over() {
+		return false
+	}
+	if p.target != nil && (g.Pieces != p.pieces || g.Current.Type != p.target.Piece.Type) {
+		// A new piece
+		p.target = nil
+	}
+	if p.target != nil && (*g.Current != p.piece || len(p.path) == 0) {
+		// Moved since the last input, so find the way from here
+		p.path = nil
+		if route, ok := routeTo(g.Board, g.Current, p.target, g.Mode.rotates180()); ok {
+			p.path = route.Path
+		} else {
+			p.target = nil
+		}
+	}
+	if p.target == nil {
+		move, ok := p.Bot.Choose(g)
+		if !ok {
+			// Every placement tops out, or the bot has none to give, so
+			// drop the piece straight down
+			drop := *g.Current
+			for test := drop; !g.Board.Collides(&test); test.Row++ {
+				drop = test
+			}
+			move = AIMove{Placement: Placement{Piece: drop, Path: []Action{ActionHardDrop}}}
+		}
+		p.target, p.path, p.pieces = &move.Placement, move.Placement.Path, g.Pieces
+		if move.Hold {
+			// The placement is for the piece swapped in, from its spawn
+			g.Apply(ActionHold)
+			if g.Current != nil {
+				p.piece = *g.Current
+			}
+			return true
+		}
+	}
+
+	action := p.path[0]
+	if action == ActionHardDrop && g.Mode.NoHardDrop {
+		// Soft drop until it lands, then wait for it to lock
+		test := *g.Current
+		test.Row++
+		if g.Board.Collides(&test) {
+			return false
+		}
+		action = ActionSoftDrop
+	} else {
+		p.path = p.path[1:]
+	}
+	g.Apply(action)
+	if g.Current != nil {
+		p.piece = *g.Current
+	}
+	return true
+}
+
+// PlayPiece gives the game every input for its current piece at once,
+// returning false if there is nothing to do yet, see Play
+func (p *AIPlayer) PlayPiece(g *Game) bool {
+	pieces, played := g.Pieces, false
+	for g.Pieces == pieces && p.Play(g) {
+		played = true
+	}
+	return played
+}
+
+// Hint is the AI's suggestion for the piece in play, kept while the
+// player moves the piece so the keys left to press can be shown
+type Hint struct {
+	Move   AIMove
+	pieces int       // Game.Pieces when it was chosen
+	piece  PieceType // The piece in play then
+}
+
+// NewHint returns the AI's suggestion for the game's current piece, or
+// nil if it has none
+func NewHint(ai *AI, g *Game) *Hint {
+	move, ok := ai.Choose(g)
+	if !ok {
+		return nil
+	}
+	return &Hint{Move: move, pieces: g.Pieces, piece: g.Current.Type}
+}
+
+// For reports whether the hint is for the game's current piece: the
+// one it was chosen for, or the one hold swapped in if it said to hold
+func (h *Hint) For(g *Game) bool {
+	if g.Current == nil || g.Pieces != h.pieces {
+		return false
+	}
+	return g.Current.Type == h.piece ||
+		(h.Move.Hold && g.HoldUsed && g.Current.Type == h.Move.Placement.Piece.Type)
+}
+
+// Keys returns the inputs that put the game's current piece where the
+// hint says from where it is, or false if it can't get there
+func (h *Hint) Keys(g *Game) ([]Action, bool) {
+	if h.Move.Hold && !g.HoldUsed {
+		// The placement's path starts from the held-in piece's spawn
+		return append([]Action{ActionHold}, h.Move.Placement.Path...), true
+	}
+	route, ok := routeTo(g.Board, g.Current, &h.Move.Placement, g.Mode.rotates180())
+	return route.Path, ok
+}
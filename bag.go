@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// Randomizer deals the sequence of pieces
+// Implementations save their full state as JSON so a resumed game
+// deals exactly the same pieces
+type Randomizer interface {
+	Next() PieceType
+	json.Marshaler
+	json.Unmarshaler
+}
+
+// Randomizer names used by Mode.Randomizer
+const (
+	RandomizerBag = "bag" // 7-bag, the default
+	RandomizerNES = "nes" // NES: uniform with a single reroll on repeats
+)
+
+// newRandomizer creates the named randomizer dealing the given set of
+// pieces, defaulting to the bag
+func newRandomizer(name string, seed uint64, set []PieceType) Randomizer {
+	if name == RandomizerNES {
+		return NewNESRandomizer(seed, set)
+	}
+	return NewBag(seed, set)
+}
+
+// Bag is a bag randomizer: every run of pieces contains each piece of
+// the set exactly once, in a shuffled order. With the tetrominoes this
+// is the 7-bag
+type Bag struct {
+	src    *rand.PCG
+	rng    *rand.Rand
+	set    []PieceType
+	pieces []PieceType
+}
+
+// NewBag creates a bag randomizer for a piece set seeded with the given
+// value. The same seed always produces the same piece sequence
+func NewBag(seed uint64, set []PieceType) *Bag {
+	src := rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)
+	return &Bag{
+		src: src,
+		rng: rand.New(src),
+		set: set,
+	}
+}
+
+// Next returns the next piece type, refilling the bag when empty
+func (b *Bag) Next() PieceType {
+	if len(b.pieces) == 0 {
+		b.refill()
+	}
+	next := b.pieces[0]
+	b.pieces = b.pieces[1:]
+	return next
+}
+
+// refill puts every piece of the set back into the bag in random order
+func (b *Bag) refill() {
+	b.pieces = append([]PieceType{}, b.set...)
+	b.rng.Shuffle(len(b.pieces), func(i, j int) {
+		b.pieces[i], b.pieces[j] = b.pieces[j], b.pieces[i]
+	})
+}
+
+// bagJSON is the saved form of a Bag
+type bagJSON struct {
+	RNG    []byte      `json:"rng"`
+	Pieces []PieceType `json:"pieces"`
+}
+
+// MarshalJSON saves the random generator state and the pieces left in
+// the bag so a restored bag deals exactly the same sequence
+func (b *Bag) MarshalJSON() ([]byte, error) {
+	rng, err := b.src.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(bagJSON{RNG: rng, Pieces: b.pieces})
+}
+
+// UnmarshalJSON restores a bag saved with MarshalJSON, refusing pieces
+// that aren't in the bag's set
+func (b *Bag) UnmarshalJSON(data []byte) error {
+	var saved bagJSON
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	for _, t := range saved.Pieces {
+		if !slices.Contains(b.set, t) {
+			return fmt.Errorf("piece %d in the bag is not in the piece set", t)
+		}
+	}
+	src := &rand.PCG{}
+	if err := src.UnmarshalBinary(saved.RNG); err != nil {
+		return err
+	}
+	b.src = src
+	b.rng = rand.New(src)
+	b.pieces = saved.Pieces
+	return nil
+}
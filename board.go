@@ -1,6 +1,11 @@
 package main
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
 
 // CellColor represents the color of a cell on the board
 type CellColor string
@@ -14,6 +19,11 @@ const (
 	ColorRed    CellColor = "#e46876" // Z piece (Kanagawa red)
 	ColorBlue   CellColor = "#7e9cd8" // J piece (Kanagawa primary blue)
 	ColorOrange CellColor = "#ffa066" // L piece (Kanagawa orange)
+
+	ColorGarbage  CellColor = "#727169" // Garbage rows (Kanagawa fuji gray)
+	ColorHoldUsed CellColor = "#54546d" // Hold piece that can't be swapped yet
+	ColorCursor   CellColor = "#dcd7ba" // Board editor cursor (Kanagawa fuji white)
+	ColorMiss     CellColor = "#e82424" // Piece placed off an opener (Kanagawa samurai red)
 )
 
 // Cell represents a single cell on the board
@@ -68,11 +78,162 @@ func (b *Board) GetCell(row, col int) Cell {
 	return NewCell()
 }
 
+// Collides reports whether the piece overlaps a filled cell or sits
+// outside the walls or floor. Cells above the top row are allowed so
+// pieces can spawn and rotate partly off-screen
+func (b *Board) Collides(p *Piece) bool {
+	if !p.Big {
+		// The common case, checked without building the cell list
+		for _, offset := range pieceRotation(p.System, p.Type).Shape(p.Type, p.Rotation) {
+			if b.blocked(p.Row+offset.Row, p.Col+offset.Col) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, cell := range p.Cells() {
+		if b.blocked(cell.Row, cell.Col) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocked reports whether a piece cell can't go at the position: a
+// filled cell, or outside the walls or floor
+func (b *Board) blocked(row, col int) bool {
+	if col < 0 || col >= BoardWidth || row >= BoardHeight {
+		return true
+	}
+	return row >= 0 && b.Cells[row][col].Filled
+}
+
+// Lock writes the piece into the board cells
+// Returns false if any part of the piece is above the top row,
+// which means the stack has topped out
+func (b *Board) Lock(p *Piece) bool {
+	inside := true
+	color := p.Color()
+	for _, cell := range p.Cells() {
+		if cell.Row < 0 {
+			inside = false
+			continue
+		}
+		b.SetCell(cell.Row, cell.Col, NewFilledCell(color))
+	}
+	return inside
+}
+
+// ClearLines removes every completely filled row, shifting the rows
+// above it down, and returns how many rows were cleared
+func (b *Board) ClearLines() int {
+	cleared := 0
+	// Walk from the bottom up, copying kept rows down by the number
+	// of rows cleared so far
+	for row := BoardHeight - 1; row >= 0; row-- {
+		if b.rowFull(row) {
+			cleared++
+			continue
+		}
+		if cleared > 0 {
+			b.Cells[row+cleared] = b.Cells[row]
+		}
+	}
+	// Fill the vacated rows at the top with empty cells
+	for row := 0; row < cleared; row++ {
+		for col := 0; col < BoardWidth; col++ {
+			b.Cells[row][col] = NewCell()
+		}
+	}
+	return cleared
+}
+
+// AddGarbageRow pushes the whole stack up one row and fills the bottom
+// row with garbage, leaving a single hole at the given column
+// Returns false if a filled cell was pushed off the top of the board
+func (b *Board) AddGarbageRow(hole int) bool {
+	overflow := false
+	for col := 0; col < BoardWidth; col++ {
+		if b.Cells[0][col].Filled {
+			overflow = true
+		}
+	}
+
+	for row := 0; row < BoardHeight-1; row++ {
+		b.Cells[row] = b.Cells[row+1]
+	}
+	for col := 0; col < BoardWidth; col++ {
+		if col == hole {
+			b.Cells[BoardHeight-1][col] = NewCell()
+		} else {
+			b.Cells[BoardHeight-1][col] = NewFilledCell(ColorGarbage)
+		}
+	}
+	return !overflow
+}
+
+// FullGarbageRows counts the completely filled rows that contain
+// garbage, i.e. the garbage lines the next ClearLines will remove
+func (b *Board) FullGarbageRows() int {
+	count := 0
+	for row := 0; row < BoardHeight; row++ {
+		if !b.rowFull(row) {
+			continue
+		}
+		for col := 0; col < BoardWidth; col++ {
+			if b.Cells[row][col].Color == ColorGarbage {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// FullRows counts the completely filled rows
+func (b *Board) FullRows() int {
+	count := 0
+	for row := 0; row < BoardHeight; row++ {
+		if b.rowFull(row) {
+			count++
+		}
+	}
+	return count
+}
+
+// Empty reports whether no cell on the board is filled
+func (b *Board) Empty() bool {
+	for row := 0; row < BoardHeight; row++ {
+		for col := 0; col < BoardWidth; col++ {
+			if b.Cells[row][col].Filled {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rowFull reports whether every cell in the row is filled
+func (b *Board) rowFull(row int) bool {
+	for col := 0; col < BoardWidth; col++ {
+		if !b.Cells[row][col].Filled {
+			return false
+		}
+	}
+	return true
+}
+
 // Render converts the board to a string for display with scaling
 // scale determines how many terminal characters each cell uses
 // scale=1: each cell is 2 chars wide × 1 line tall
 // scale=2: each cell is 4 chars wide × 2 lines tall, etc.
 func (b *Board) Render(scale int) string {
+	return b.RenderOutline(scale, nil, "")
+}
+
+// RenderOutline renders the board like Render, with the given empty
+// cells drawn as outlines in color
+func (b *Board) RenderOutline(scale int, outline []Offset, color CellColor) string {
 	if scale < 1 {
 		scale = 1
 	}
@@ -94,6 +255,10 @@ func (b *Board) Render(scale int) string {
 						blocks += "█"
 					}
 					result += style.Render(blocks)
+				} else if slices.Contains(outline, Offset{Row: row, Col: col}) {
+					// Bracket the cell, spaced to the scaled width
+					style := lipgloss.NewStyle().Foreground(lipgloss.Color(color))
+					result += style.Render("[" + strings.Repeat(" ", charsPerCell-2) + "]")
 				} else {
 					// Render empty cell as dots
 					style := lipgloss.NewStyle().Foreground(lipgloss.Color("#54546d")) // Dim gray from Kanagawa
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// Classic mode follows NES Tetris: levels start at 0, pieces fall at
+// a fixed number of frames per row for each level, and line clears
+// score 40/100/300/1200 times the next level
+
+// MaxClassicStartLevel is the highest level selectable for Classic
+const MaxClassicStartLevel = 19
+
+// nesLineScores is the base score for clearing 0-4 lines at once,
+// multiplied by level+1
+var nesLineScores = [5]int{0, 40, 100, 300, 1200}
+
+// nesFramesPerRow returns how many frames a piece takes to fall one row
+// at a level on the NTSC NES
+func nesFramesPerRow(level int) int {
+	speeds := []int{48, 43, 38, 33, 28, 23, 18, 13, 8, 6}
+	switch {
+	case level < len(speeds):
+		return speeds[max(level, 0)]
+	case level <= 12:
+		return 5
+	case level <= 15:
+		return 4
+	case level <= 18:
+		return 3
+	case level <= 28:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// nesGravity returns the fall speed for an NES level in 1/gravityUnit
+// cells per frame, rounded up so a row takes exactly the table's frames
+func nesGravity(level int) int {
+	frames := nesFramesPerRow(level)
+	return (gravityUnit + frames - 1) / frames
+}
+
+// nesLevel returns the level after clearing lines from a start level
+// The first level up takes start×10+10 lines, capped between 100 and
+// start×10-50, which is why starting at 18 or 19 holds the level for
+// 130 or 140 lines; after that every 10 lines is a level
+func nesLevel(start, lines int) int {
+	first := min(start*10+10, max(100, start*10-50))
+	if lines < first {
+		return start
+	}
+	return start + 1 + (lines-first)/10
+}
+
+// scoreNES scores a lock with NES line clear values
+func (g *Game) scoreNES(cleared int) {
+	g.Score += nesLineScores[cleared] * (g.Level + 1)
+	g.Lines += cleared
+	g.Level = nesLevel(g.Mode.StartLevel, g.Lines)
+}
+
+// NESRandomizer picks each piece uniformly, rerolling once if it
+// repeats the previous piece, like NES Tetris
+type NESRandomizer struct {
+	src     *rand.PCG
+	rng     *rand.Rand
+	set     []PieceType
+	prev    PieceType
+	started bool
+}
+
+// NewNESRandomizer creates an NES randomizer for a piece set seeded
+// with the given value
+func NewNESRandomizer(seed uint64, set []PieceType) *NESRandomizer {
+	src := rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)
+	return &NESRandomizer{
+		src: src,
+		rng: rand.New(src),
+		set: set,
+	}
+}
+
+// Next returns the next piece type
+// The NES rolls one more value than there are pieces; rolling the
+// unused value or the previous piece triggers a single reroll
+func (r *NESRandomizer) Next() PieceType {
+	roll := r.rng.IntN(len(r.set) + 1)
+	if roll == len(r.set) || (r.started && r.set[roll] == r.prev) {
+		roll = r.rng.IntN(len(r.set))
+	}
+	r.prev = r.set[roll]
+	r.started = true
+	return r.prev
+}
+
+// nesRandomizerJSON is the saved form of an NESRandomizer
+type nesRandomizerJSON struct {
+	RNG     []byte    `json:"rng"`
+	Prev    PieceType `json:"prev"`
+	Started bool      `json:"started"`
+}
+
+// MarshalJSON saves the random generator state and the last piece
+func (r *NESRandomizer) MarshalJSON() ([]byte, error) {
+	rng, err := r.src.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(nesRandomizerJSON{RNG: rng, Prev: r.prev, Started: r.started})
+}
+
+// UnmarshalJSON restores a randomizer saved with MarshalJSON, refusing
+// a previous piece that isn't in the randomizer's set
+func (r *NESRandomizer) UnmarshalJSON(data []byte) error {
+	var saved nesRandomizerJSON
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	if saved.Started && !slices.Contains(r.set, saved.Prev) {
+		return fmt.Errorf("previous piece %d is not in the piece set", saved.Prev)
+	}
+	src := &rand.PCG{}
+	if err := src.UnmarshalBinary(saved.RNG); err != nil {
+		return err
+	}
+	r.src = src
+	r.rng = rand.New(src)
+	r.prev = saved.Prev
+	r.started = saved.Started
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runCommand runs the command-line subcommand named by args, if any,
+// returning its exit code and whether args named one
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "fumen":
+		return runFumen(args[1:], stdout, stderr), true
+	case "placements":
+		return runPlacements(args[1:], stdout, stderr), true
+	case "ai":
+		return runAI(args[1:], stdout, stderr), true
+	case "bot":
+		return runBot(args[1:], stdin, stdout, stderr), true
+	}
+	return 0, false
+}
+
+// fumenUsage is the help for the fumen subcommand
+const fumenUsage = `usage:
+  gotetris fumen decode <fumen>          print each page of a fumen
+  gotetris fumen encode <position>       encode a board editor position, or
+                                         a position in board notation
+`
+
+// runFumen converts between fumens and boards
+func runFumen(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprint(stderr, fumenUsage)
+		return 2
+	}
+	switch args[0] {
+	case "decode":
+		pages, err := DecodeFumen(args[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		for i, page := range pages {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprint(stdout, describeFumenPage(i+1, page))
+		}
+	case "encode":
+		pos, err := LoadPosition(args[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		fumen, err := PositionFumen(pos)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, fumen)
+	default:
+		fmt.Fprint(stderr, fumenUsage)
+		return 2
+	}
+	return 0
+}
+
+// describeFumenPage renders a fumen page as text: its piece and flags,
+// its comment, and the stack in board notation with the piece in lower
+// case
+func describeFumenPage(n int, page FumenPage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Page %d", n)
+	if p := page.Piece; p != nil {
+		fmt.Fprintf(&b, ": %s %s at row %d, col %d", p.Type, p.Rotation, p.Row, p.Col)
+	}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{!page.Lock, "no lock"}, {page.Rise, "rise"}, {page.Mirror, "mirror"}} {
+		if flag.set {
+			b.WriteString(", " + flag.name)
+		}
+	}
+	b.WriteString("\n")
+	if page.Comment != "" {
+		fmt.Fprintf(&b, "Comment: %s\n", page.Comment)
+	}
+
+	rows := make([][]byte, BoardHeight)
+	top := BoardHeight - 1
+	for row := range rows {
+		rows[row] = make([]byte, BoardWidth)
+		for col, cell := range page.Board.Cells[row] {
+			rows[row][col] = cellLetter(cell)
+			if cell.Filled {
+				top = min(top, row)
+			}
+		}
+	}
+	if page.Piece != nil {
+		for _, cell := range page.Piece.Cells() {
+			if cell.Row >= 0 && cell.Row < BoardHeight {
+				rows[cell.Row][cell.Col] = strings.ToLower(page.Piece.Type.String())[0]
+				top = min(top, cell.Row)
+			}
+		}
+	}
+	for _, row := range rows[top:] {
+		b.Write(row)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// placementsUsage is the help for the placements subcommand
+const placementsUsage = `usage:
+  gotetris placements <position> [piece] list every placement the piece
+                                         (or the first in the position's
+                                         queue) can reach, with its inputs
+`
+
+// runPlacements lists the placements a piece can reach on a position's
+// board, with the shortest inputs to each
+func runPlacements(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprint(stderr, placementsUsage)
+		return 2
+	}
+	pos, err := LoadPosition(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	var pieces []PieceType
+	if len(args) == 2 {
+		pieces, err = parsePieceLetters(args[1])
+	} else {
+		pieces = pos.Queue
+	}
+	if err != nil || len(pieces) != 1 && len(args) == 2 {
+		fmt.Fprintln(stderr, "Error: piece must be one of I, O, T, S, Z, J or L")
+		return 1
+	}
+	if len(pieces) == 0 {
+		fmt.Fprintln(stderr, "Error: position has no queue, name the piece to place")
+		return 1
+	}
+
+	placements := Placements(pos.Board, pieces[0], NewMarathonMode(1))
+	for _, p := range placements {
+		fmt.Fprintf(stdout, "%s %s at row %d, col %d", p.Piece.Type, p.Piece.Rotation, p.Piece.Row, p.Piece.Col)
+		if p.Spin != SpinNone {
+			fmt.Fprintf(stdout, " (%s)", p.Spin)
+		}
+		fmt.Fprintf(stdout, ": %s\n", describePath(p.Path))
+	}
+	fmt.Fprintf(stdout, "%d placements\n", len(placements))
+	return 0
+}
+
+// aiModes are the modes the ai subcommand can play, with the menu's
+// default settings
+func aiModes() []Mode {
+	return []Mode{
+		NewMarathonMode(1),
+		NewEndlessMode(1),
+		NewUltraMode(DefaultUltraTime),
+		NewDigMode(DefaultDigGoal, DefaultMessiness),
+		NewSurvivalMode(DefaultMessiness),
+		NewMasterMode(),
+		NewClassicMode(0),
+		NewPieceSetMode(ModePentomino, "Pentomino", PieceSetPentomino, 1),
+		NewPieceSetMode(ModeTriomino, "Tri-Tetra", PieceSetTriomino, 1),
+	}
+}
+
+// runAI plays a game with the AI headless, as fast as it can, and
+// prints how it went
+func runAI(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ai", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	modeID := fs.String("mode", string(ModeMarathon), "mode to play")
+	seed := fs.Uint64("seed", 0, "seed for the game (0 = random)")
+	limit := fs.Int("pieces", 0, "stop after this many pieces (0 = play until the game ends)")
+	lookahead := fs.Int("lookahead", DefaultAILookahead, "preview pieces the AI looks at")
+	beam := fs.Int("beam", DefaultAIBeam, "boards the AI keeps at each piece it looks ahead")
+	weightsFile := fs.String("weights", "", "JSON file of AI weights (default: ai.json in the gotetris data directory, if any)")
+	replayFile := fs.String("replay", "", "save the game's replay to this file")
+	botCommand := fs.String("bot", "", "play with an outside bot run by this command, which speaks the Tetris Bot Protocol")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gotetris ai [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	i := slices.IndexFunc(aiModes(), func(m Mode) bool { return string(m.ID) == *modeID })
+	if i < 0 {
+		var ids []string
+		for _, m := range aiModes() {
+			ids = append(ids, string(m.ID))
+		}
+		fmt.Fprintf(stderr, "Error: mode must be one of %s\n", strings.Join(ids, ", "))
+		return 2
+	}
+	mode := aiModes()[i]
+	weights, err := loadAIWeights(*weightsFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: could not load AI weights: %v\n", err)
+		return 1
+	}
+	ai := NewAI(weights)
+	ai.Lookahead, ai.Beam = *lookahead, *beam
+	var bot Bot = ai
+	var tbp *TBPBot
+	if *botCommand != "" {
+		if !tbpSupports(mode) {
+			fmt.Fprintf(stderr, "Error: bots can't play %s: the Tetris Bot Protocol only has the tetrominoes, with hold\n", mode.Name)
+			return 2
+		}
+		tbp, err = StartTBPBot(strings.Fields(*botCommand), stderr)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: could not start the bot: %v\n", err)
+			return 1
+		}
+		defer tbp.Close()
+		bot = tbp
+	}
+	if *seed == 0 {
+		*seed = uint64(time.Now().UnixNano())
+	}
+
+	g := NewGame(mode, *seed)
+	if *replayFile != "" {
+		g.StartRecording(*seed, Position{})
+	}
+	player := NewAIPlayer(bot)
+	var clears [5]int
+	spins := 0
+	start := time.Now()
+	for !g.Over() && (*limit == 0 || g.Pieces < *limit) {
+		// Without hard drop the piece locks during Step, so count after it
+		lines := g.Lines
+		player.PlayPiece(g)
+		g.Step()
+		if g.Lines > lines {
+			clears[min(g.Lines-lines, 4)]++
+			if g.LastSpin == SpinFull {
+				spins++
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	if tbp != nil && tbp.Err() != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", tbp.Err())
+		return 1
+	}
+
+	result := map[GameResult]string{
+		ResultNone:    "Stopped",
+		ResultTopOut:  "Topped out",
+		ResultTimeUp:  "Time up",
+		ResultCleared: "Cleared",
+		ResultFailed:  "Failed",
+	}[g.Result]
+	if tbp != nil {
+		fmt.Fprintf(stdout, "%s %s by %s\n", tbp.Info.Name, tbp.Info.Version, tbp.Info.Author)
+	}
+	fmt.Fprintf(stdout, "%s, seed %d: %s\n", mode.Name, *seed, result)
+	fmt.Fprintf(stdout, "Score:  %d\n", g.Score)
+	fmt.Fprintf(stdout, "Lines:  %d (%d singles, %d doubles, %d triples, %d tetrises, %d T-spins)\n",
+		g.Lines, clears[1], clears[2], clears[3], clears[4], spins)
+	fmt.Fprintf(stdout, "Level:  %d\n", g.Level)
+	fmt.Fprintf(stdout, "Pieces: %d", g.Pieces)
+	if g.Pieces > 0 {
+		fmt.Fprintf(stdout, " (%s each to think)", (elapsed / time.Duration(g.Pieces)).Round(time.Microsecond))
+	}
+	fmt.Fprintf(stdout, "\nTime:   %s\n", formatFrames(g.Frame))
+
+	if *replayFile != "" {
+		r := g.Recording()
+		if !g.Over() {
+			// Stopped early, so finish the replay by hand
+			r.Frames, r.Hash = g.Frame, g.StateHash()
+		}
+		if err := SaveReplay(*replayFile, r); err != nil {
+			fmt.Fprintf(stderr, "Error: could not save replay: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// runBot runs the AI as a bot speaking the Tetris Bot Protocol on stdin
+// and stdout, for games that play through it, gotetris's own included
+func runBot(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	lookahead := fs.Int("lookahead", DefaultAILookahead, "preview pieces the AI looks at")
+	beam := fs.Int("beam", DefaultAIBeam, "boards the AI keeps at each piece it looks ahead")
+	weightsFile := fs.String("weights", "", "JSON file of AI weights (default: ai.json in the gotetris data directory, if any)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gotetris bot [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	weights, err := loadAIWeights(*weightsFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: could not load AI weights: %v\n", err)
+		return 1
+	}
+	ai := NewAI(weights)
+	ai.Lookahead, ai.Beam = *lookahead, *beam
+	if err := ServeTBP(ai, stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// MaxPositionQueue is the longest queue a position can set
+const MaxPositionQueue = 28
+
+// Position is a starting point for a game: the stack, the pieces to
+// deal first and the held piece
+type Position struct {
+	Board   *Board      `json:"board"`
+	Queue   []PieceType `json:"queue,omitempty"` // Dealt in order before the randomizer's pieces
+	Hold    PieceType   `json:"hold"`
+	HasHold bool        `json:"has_hold"`
+}
+
+// SavePosition writes a position to path, creating its directory if
+// needed
+func SavePosition(path string, pos Position) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pos, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadPosition reads a position saved with SavePosition, or written
+// in board notation (see ParsePosition)
+func LoadPosition(path string) (Position, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Position{}, err
+	}
+	var pos Position
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return ParsePosition(string(data))
+	}
+	if err := json.Unmarshal(data, &pos); err != nil {
+		return Position{}, err
+	}
+	if pos.Board == nil {
+		return Position{}, errors.New("position has no board")
+	}
+	if len(pos.Queue) > MaxPositionQueue {
+		return Position{}, fmt.Errorf("position queue has %d pieces, at most %d allowed", len(pos.Queue), MaxPositionQueue)
+	}
+	for _, t := range slices.Concat(pos.Queue, []PieceType{pos.Hold}) {
+		if !slices.Contains(Tetrominoes, t) {
+			return Position{}, fmt.Errorf("position piece %d is not a tetromino", t)
+		}
+	}
+	return pos, nil
+}
+
+// Editor builds a Position: cells are painted and erased under a
+// cursor, or a whole piece is stamped at once, and the queue and hold
+// are set from the brush
+type Editor struct {
+	Position
+	Row, Col int    // Cursor
+	Brush    int    // Index into editorBrushes
+	Stamp    *Piece // Piece being placed, nil when painting cells
+}
+
+// editorBrushes are the piece types the editor paints with, then
+// garbage (-1), which can't be queued, held or stamped
+var editorBrushes = []PieceType{PieceI, PieceO, PieceT, PieceS, PieceZ, PieceJ, PieceL, -1}
+
+// NewEditor creates an editor for a position
+func NewEditor(pos Position) *Editor {
+	e := &Editor{Position: pos, Row: BoardHeight - 1}
+	e.Board = NewBoard()
+	if pos.Board != nil {
+		*e.Board = *pos.Board
+	}
+	e.Queue = slices.Clone(pos.Queue)
+	return e
+}
+
+// brushPiece returns the brush's piece type, or false for garbage
+func (e *Editor) brushPiece() (PieceType, bool) {
+	t := editorBrushes[e.Brush]
+	return t, t >= 0
+}
+
+// BrushColor returns the color cells are painted in
+func (e *Editor) BrushColor() CellColor {
+	if t, ok := e.brushPiece(); ok {
+		return (&Piece{Type: t}).Color()
+	}
+	return ColorGarbage
+}
+
+// BrushName returns the brush's piece name, or "Garbage"
+func (e *Editor) BrushName() string {
+	if t, ok := e.brushPiece(); ok {
+		return t.String()
+	}
+	return "Garbage"
+}
+
+// SetBrush selects a brush by index, wrapping around
+func (e *Editor) SetBrush(i int) {
+	e.Brush = (i + len(editorBrushes)) % len(editorBrushes)
+	if e.Stamp != nil {
+		if t, ok := e.brushPiece(); ok {
+			e.Stamp.Type = t
+		} else {
+			e.Stamp = nil
+		}
+	}
+}
+
+// Move moves the cursor, or the stamp as long as it stays on the board
+func (e *Editor) Move(dRow, dCol int) {
+	if e.Stamp != nil {
+		moved := *e.Stamp
+		moved.Row += dRow
+		moved.Col += dCol
+		if onBoard(&moved) {
+			e.Stamp = &moved
+		}
+		return
+	}
+	e.Row = min(max(e.Row+dRow, 0), BoardHeight-1)
+	e.Col = min(max(e.Col+dCol, 0), BoardWidth-1)
+}
+
+// MoveTo puts the cursor on a cell, taking the stamp with it if it
+// fits on the board there
+func (e *Editor) MoveTo(row, col int) {
+	e.Row, e.Col = row, col
+	if e.Stamp != nil {
+		moved := *e.Stamp
+		moved.Row, moved.Col = row, col
+		if onBoard(&moved) {
+			e.Stamp = &moved
+		}
+	}
+}
+
+// Paint fills the cell under the cursor with the brush, or stamps the
+// piece being placed
+func (e *Editor) Paint() {
+	if e.Stamp != nil {
+		for _, cell := range e.Stamp.Cells() {
+			e.Board.SetCell(cell.Row, cell.Col, NewFilledCell(e.Stamp.Color()))
+		}
+		return
+	}
+	e.Board.SetCell(e.Row, e.Col, NewFilledCell(e.BrushColor()))
+}
+
+// Erase empties the cell under the cursor
+func (e *Editor) Erase() {
+	e.Board.SetCell(e.Row, e.Col, NewCell())
+}
+
+// Clear empties the whole board
+func (e *Editor) Clear() {
+	e.Board = NewBoard()
+}
+
+// ToggleStamp switches between painting cells and stamping pieces
+// The stamp starts at the cursor, pulled back onto the board if needed
+func (e *Editor) ToggleStamp() {
+	t, ok := e.brushPiece()
+	if e.Stamp != nil || !ok {
+		e.Stamp = nil
+		return
+	}
+	stamp := &Piece{Type: t, Row: e.Row, Col: e.Col}
+	below, right := 0, 0
+	for _, cell := range stamp.Cells() {
+		below = max(below, cell.Row-(BoardHeight-1))
+		right = max(right, cell.Col-(BoardWidth-1))
+	}
+	stamp.Row -= below
+	stamp.Col -= right
+	if onBoard(stamp) {
+		e.Stamp = stamp
+	}
+}
+
+// Rotate turns the stamp a quarter turn, if it still fits on the board
+func (e *Editor) Rotate(dir int) {
+	if e.Stamp == nil {
+		return
+	}
+	turned := *e.Stamp
+	turned.Rotation = (turned.Rotation + RotationState(dir) + 4) % 4
+	if onBoard(&turned) {
+		e.Stamp = &turned
+	}
+}
+
+// PushQueue adds the brush's piece to the end of the queue
+func (e *Editor) PushQueue() {
+	if t, ok := e.brushPiece(); ok && len(e.Queue) < MaxPositionQueue {
+		e.Queue = append(e.Queue, t)
+	}
+}
+
+// PopQueue removes the last piece of the queue
+func (e *Editor) PopQueue() {
+	if len(e.Queue) > 0 {
+		e.Queue = e.Queue[:len(e.Queue)-1]
+	}
+}
+
+// ToggleHold holds the brush's piece, or empties hold if it already
+// holds that piece
+func (e *Editor) ToggleHold() {
+	t, ok := e.brushPiece()
+	if !ok {
+		return
+	}
+	if e.HasHold && e.Hold == t {
+		e.HasHold = false
+		return
+	}
+	e.Hold, e.HasHold = t, true
+}
+
+// Snapshot returns a copy of the position being edited
+func (e *Editor) Snapshot() Position {
+	board := *e.Board
+	return Position{Board: &board, Queue: slices.Clone(e.Queue), Hold: e.Hold, HasHold: e.HasHold}
+}
+
+// onBoard reports whether every cell of a piece is on the board
+func onBoard(p *Piece) bool {
+	for _, cell := range p.Cells() {
+		if cell.Row < 0 || cell.Row >= BoardHeight || cell.Col < 0 || cell.Col >= BoardWidth {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// Finesse is placing each piece with the fewest keys. A key is a tap,
+// a shift into the wall (held past DAS, or ActionDASLeft/Right) or a
+// rotation; drops aren't counted. Each placement is judged against a
+// search over keys from the piece's spawn state, so only placements a
+// hard drop can reach from the top are judged: tucks and spins that
+// need a soft drop are left out
+
+// Finesse tallies how pieces were placed over a game
+type Finesse struct {
+	Judged     int  // Pieces judged
+	Faults     int  // Judged pieces placed with more keys than needed
+	LastKeys   int  // Keys pressed for the last piece judged
+	LastBest   int  // Fewest keys that could have placed it
+	LastMissed bool // The last piece missed the finesse trainer's target
+}
+
+// Percent returns the share of judged pieces placed without a fault
+func (f Finesse) Percent() int {
+	if f.Judged == 0 {
+		return 100
+	}
+	return (f.Judged - f.Faults) * 100 / f.Judged
+}
+
+// finesseKeys are the keys the finesse search presses
+var finesseKeys = []Action{
+	ActionLeft, ActionRight, ActionDASLeft, ActionDASRight,
+	ActionRotateCW, ActionRotateCCW, ActionRotate180,
+}
+
+// finessePlacement is a placement a hard drop can reach, as the landed
+// piece, and the fewest keys that reach it
+type finessePlacement struct {
+	Piece Piece
+	Keys  int
+}
+
+// finesseSearch returns every placement a hard drop can reach from a
+// piece's spawn state, with the fewest keys that reach each, in the
+// order a breadth-first search over keys finds them
+func finesseSearch(b *Board, start *Piece, rotate180 bool) []finessePlacement {
+	var placements []finessePlacement
+	found := map[string]bool{}
+	keys := map[Piece]int{*start: 0}
+	queue := []Piece{*start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		landed := dropPiece(b, p)
+		if key := placementKey(&landed); !found[key] {
+			// Every key costs the same, so the first path found to a
+			// placement is a shortest one
+			found[key] = true
+			placements = append(placements, finessePlacement{Piece: landed, Keys: keys[p]})
+		}
+
+		for _, action := range finesseKeys {
+			if action == ActionRotate180 && !rotate180 {
+				continue
+			}
+			next, ok := pressKey(b, p, action)
+			if _, seen := keys[next]; !ok || seen {
+				continue
+			}
+			keys[next] = keys[p] + 1
+			queue = append(queue, next)
+		}
+	}
+	return placements
+}
+
+// pressKey returns where a key moves a piece on the board, or false if
+// it can't move
+func pressKey(b *Board, p Piece, action Action) (Piece, bool) {
+	switch action {
+	case ActionLeft, ActionRight, ActionDASLeft, ActionDASRight:
+		dir := 1
+		if action == ActionLeft || action == ActionDASLeft {
+			dir = -1
+		}
+		moved := p
+		for {
+			test := moved
+			test.Col += dir
+			if b.Collides(&test) {
+				break
+			}
+			moved = test
+			if action == ActionLeft || action == ActionRight {
+				break
+			}
+		}
+		return moved, moved != p
+	case ActionRotateCW, ActionRotateCCW, ActionRotate180:
+		turned, _, ok := turnPiece(b, &p, turnDirs[action])
+		return turned, ok
+	}
+	return p, false
+}
+
+// dropPiece returns a piece moved straight down as far as it goes
+func dropPiece(b *Board, p Piece) Piece {
+	for {
+		test := p
+		test.Row++
+		if b.Collides(&test) {
+			return p
+		}
+		p = test
+	}
+}
+
+// placementKey identifies the cells a piece covers, so placements of
+// pieces that look the same in different states (an O in any state, or
+// an I, S or Z turned either way) are the same placement
+func placementKey(p *Piece) string {
+	return cellsKey(p.Cells())
+}
+
+// cellsKey identifies a set of board cells, whatever their order
+func cellsKey(cells []Offset) string {
+	cells = slices.Clone(cells)
+	slices.SortFunc(cells, func(a, b Offset) int {
+		if a.Row != b.Row {
+			return a.Row - b.Row
+		}
+		return a.Col - b.Col
+	})
+	// A byte per coordinate, offset so cells above the board fit too
+	key := make([]byte, 0, 2*len(cells))
+	for _, cell := range cells {
+		key = append(key, byte(cell.Row+BoardHeight), byte(cell.Col+BoardWidth))
+	}
+	return string(key)
+}
+
+// judgeFinesse compares the keys pressed for the piece about to lock
+// with the fewest that could have put it where it is
+// Nothing is judged at 20G, where pieces can't be steered from the top
+func (g *Game) judgeFinesse() {
+	if !g.Mode.NoGravity && g.gravity() >= 20*gravityUnit {
+		return
+	}
+	key := placementKey(g.Current)
+	for _, placement := range finesseSearch(g.Board, g.Mode.spawnPiece(g.Current.Type), g.Mode.rotates180()) {
+		if placementKey(&placement.Piece) != key {
+			continue
+		}
+		g.Finesse.Judged++
+		g.Finesse.LastKeys = g.keys
+		g.Finesse.LastBest = placement.Keys
+		if g.keys > placement.Keys {
+			g.Finesse.Faults++
+		}
+		return
+	}
+}
+
+// trainFinesse checks a placement in the finesse trainer instead of
+// locking it: a piece put on the target with the fewest keys moves on
+// to the next piece and a new target, anything else tries the same
+// target again. The board stays empty
+func (g *Game) trainFinesse() {
+	g.Pieces++
+	f := &g.Finesse
+	f.Judged++
+	f.LastKeys, f.LastBest = g.keys, g.TargetKeys
+	f.LastMissed = placementKey(g.Current) != placementKey(g.Target)
+
+	retry := g.Current.Type
+	g.Current = nil
+	if f.LastMissed || g.keys > g.TargetKeys {
+		f.Faults++
+		g.spawn(retry)
+		return
+	}
+	g.spawnNext()
+	g.newTarget()
+}
+
+// newTarget picks the finesse trainer's target for the current piece
+// from the placements a hard drop can reach. The pick depends only on
+// the game so far, so replays and saves pick the same targets
+func (g *Game) newTarget() {
+	if g.Current == nil {
+		return
+	}
+	placements := finesseSearch(g.Board, g.Mode.spawnPiece(g.Current.Type), g.Mode.rotates180())
+	var queue uint64
+	for _, t := range g.Queue {
+		queue = queue*31 + uint64(t)
+	}
+	rng := rand.New(rand.NewPCG(uint64(g.Frame)<<32|uint64(g.Pieces), queue))
+	pick := placements[rng.IntN(len(placements))]
+	g.Target = &pick.Piece
+	g.TargetKeys = pick.Keys
+}
+
+// Keys returns the moves and rotations pressed for the current piece
+func (g *Game) Keys() int {
+	return g.keys
+}
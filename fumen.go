@@ -0,0 +1,709 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Fumen is the diagram format most Tetris players share setups in
+// This is the current version, v115: a field of 23 rows plus a garbage
+// row below, and pages that each show a piece and a comment, encoded
+// as differences from the page before in a base64 alphabet
+
+const (
+	fumenPrefix = "v115@"
+	fumenTop    = 23                  // Rows of the fumen field
+	fumenBlocks = (fumenTop + 1) * 10 // Cells including the garbage row
+	fumenTable  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	fumenChars  = " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+
+	// fumenCommentMax is the longest comment a page can hold, escaped
+	fumenCommentMax = 4095
+)
+
+// ErrFumen is returned for text that isn't a v115 fumen
+var ErrFumen = errors.New("not a v115 fumen")
+
+// FumenPage is one page of a fumen: the stack, the piece placed on it
+// and what happens to the stack for the next page
+type FumenPage struct {
+	Board   *Board                // Stack before the piece locks; nil for EncodeFumen keeps the last page's
+	Garbage [BoardWidth]CellColor // Row below the board, pushed up by Rise
+	Piece   *Piece                // Piece on the page, nil if none
+	Comment string                // Shown until a later page changes it
+
+	Lock   bool // The piece locks and full lines clear for the next page
+	Rise   bool // The garbage row rises into the stack after the lock
+	Mirror bool // The stack is mirrored after the lock
+}
+
+// fumenTypes are the fumen cell values of the tetrominoes, by
+// PieceType. 0 is an empty cell and 8 garbage
+var fumenTypes = [...]int{PieceI: 1, PieceL: 2, PieceO: 3, PieceZ: 4, PieceT: 5, PieceJ: 6, PieceS: 7}
+
+const fumenGarbage = 8
+
+// fumenRotations are fumen's rotation numbers, by RotationState
+var fumenRotations = [...]int{Rotation0: 2, RotationR: 1, Rotation2: 0, RotationL: 3}
+
+// fumenMinos are the cells of each fumen piece type in spawn state, as
+// (x, y) from the piece's origin with y up
+var fumenMinos = map[int][][2]int{
+	1: {{0, 0}, {-1, 0}, {1, 0}, {2, 0}},
+	2: {{0, 0}, {-1, 0}, {1, 0}, {1, 1}},
+	3: {{0, 0}, {1, 0}, {0, 1}, {1, 1}},
+	4: {{0, 0}, {1, 0}, {0, 1}, {-1, 1}},
+	5: {{0, 0}, {-1, 0}, {1, 0}, {0, 1}},
+	6: {{0, 0}, {-1, 0}, {1, 0}, {-1, 1}},
+	7: {{0, 0}, {-1, 0}, {0, 1}, {1, 1}},
+}
+
+// fumenField is a fumen field, indexed from the top-left cell of the
+// top row down to the garbage row
+type fumenField [fumenBlocks]int
+
+// index returns the index of a cell, y counting up from 0 for the
+// bottom row and -1 for the garbage row
+func (f *fumenField) index(x, y int) int {
+	return (fumenTop-1-y)*BoardWidth + x
+}
+
+// fill places a piece's cells in the field
+func (f *fumenField) fill(t, rotation, x, y int) {
+	for _, mino := range fumenCells(t, rotation) {
+		if i := f.index(x+mino[0], y+mino[1]); i >= 0 && i < fumenBlocks {
+			f[i] = t
+		}
+	}
+}
+
+// clearLines removes full rows above the garbage row
+func (f *fumenField) clearLines() {
+	var cleared fumenField
+	to := 0
+	for y := 0; y < fumenTop; y++ {
+		row := f[f.index(0, y) : f.index(0, y)+BoardWidth]
+		if slices.Contains(row, 0) {
+			copy(cleared[cleared.index(0, to):], row)
+			to++
+		}
+	}
+	copy(cleared[cleared.index(0, -1):], f[f.index(0, -1):])
+	*f = cleared
+}
+
+// rise pushes the garbage row into the bottom of the field
+func (f *fumenField) rise() {
+	var risen fumenField
+	copy(risen[:risen.index(0, 0)], f[f.index(0, fumenTop-2):f.index(0, -1)])
+	copy(risen[risen.index(0, 0):risen.index(0, -1)], f[f.index(0, -1):])
+	*f = risen
+}
+
+// mirror flips the field above the garbage row left to right
+func (f *fumenField) mirror() {
+	for y := 0; y < fumenTop; y++ {
+		slices.Reverse(f[f.index(0, y) : f.index(0, y)+BoardWidth])
+	}
+}
+
+// fumenCells returns the cells of a fumen piece in a fumen rotation
+func fumenCells(t, rotation int) [][2]int {
+	cells := slices.Clone(fumenMinos[t])
+	for i, c := range cells {
+		switch rotation {
+		case 0: // Reverse
+			cells[i] = [2]int{-c[0], -c[1]}
+		case 1: // Right
+			cells[i] = [2]int{c[1], -c[0]}
+		case 3: // Left
+			cells[i] = [2]int{-c[1], c[0]}
+		}
+	}
+	return cells
+}
+
+// fumenPosition returns where fumen stores a piece, which for some
+// pieces and rotations is off by a cell from its origin
+func fumenPosition(t, rotation, x, y int) int {
+	switch {
+	case t == 3 && rotation == 3:
+		x, y = x+1, y-1
+	case t == 3 && rotation == 0:
+		x++
+	case t == 3 && rotation == 2:
+		y--
+	case t == 1 && rotation == 0:
+		x++
+	case t == 1 && rotation == 3:
+		y--
+	case t == 7 && rotation == 2:
+		y--
+	case t == 7 && rotation == 1:
+		x--
+	case t == 4 && rotation == 2:
+		y--
+	case t == 4 && rotation == 3:
+		x++
+	}
+	return (fumenTop-y-1)*BoardWidth + x
+}
+
+// fumenOrigin is the inverse of fumenPosition
+func fumenOrigin(t, rotation, position int) (x, y int) {
+	x = position % BoardWidth
+	y = fumenTop - position/BoardWidth - 1
+	switch {
+	case t == 3 && rotation == 3:
+		x, y = x-1, y+1
+	case t == 3 && rotation == 0:
+		x--
+	case t == 3 && rotation == 2:
+		y++
+	case t == 1 && rotation == 0:
+		x--
+	case t == 1 && rotation == 3:
+		y++
+	case t == 7 && rotation == 2:
+		y++
+	case t == 7 && rotation == 1:
+		x++
+	case t == 4 && rotation == 2:
+		y++
+	case t == 4 && rotation == 3:
+		x--
+	}
+	return x, y
+}
+
+// fumenCellType returns the fumen value of a board cell; any color that
+// isn't a tetromino's is garbage
+func fumenCellType(cell Cell) int {
+	if !cell.Filled {
+		return 0
+	}
+	if t, ok := colorPiece(cell.Color); ok {
+		return fumenTypes[t]
+	}
+	return fumenGarbage
+}
+
+// fumenCellColor returns the board color of a fumen value
+func fumenCellColor(value int) CellColor {
+	if i := slices.Index(fumenTypes[:], value); i >= 0 {
+		return (&Piece{Type: PieceType(i)}).Color()
+	}
+	return ColorGarbage
+}
+
+// fumenFieldOf returns the fumen field of a page's stack and garbage row
+func fumenFieldOf(b *Board, garbage [BoardWidth]CellColor) fumenField {
+	var f fumenField
+	for row := 0; row < BoardHeight; row++ {
+		for col := 0; col < BoardWidth; col++ {
+			f[f.index(col, BoardHeight-1-row)] = fumenCellType(b.Cells[row][col])
+		}
+	}
+	for col, color := range garbage {
+		if color != ColorEmpty {
+			f[f.index(col, -1)] = fumenCellType(NewFilledCell(color))
+		}
+	}
+	return f
+}
+
+// board returns the stack and garbage row of a fumen field, which must
+// be empty above the board's BoardHeight rows
+func (f *fumenField) board() (*Board, [BoardWidth]CellColor, error) {
+	b := NewBoard()
+	var garbage [BoardWidth]CellColor
+	for y := -1; y < fumenTop; y++ {
+		for x := 0; x < BoardWidth; x++ {
+			value := f[f.index(x, y)]
+			switch {
+			case value == 0:
+			case value < 0 || value > fumenGarbage:
+				return nil, garbage, fmt.Errorf("cell value %d is not valid", value)
+			case y >= BoardHeight:
+				return nil, garbage, fmt.Errorf("stack is above row %d", BoardHeight)
+			case y < 0:
+				garbage[x] = fumenCellColor(value)
+			default:
+				b.Cells[BoardHeight-1-y][x] = NewFilledCell(fumenCellColor(value))
+			}
+		}
+	}
+	return b, garbage, nil
+}
+
+// fumenPiece returns a fumen piece's type, rotation and origin
+// It's matched by its cells, so any rotation system's shapes convert
+func fumenPiece(p *Piece) (t, rotation, x, y int, err error) {
+	if p.Big || int(p.Type) >= len(fumenTypes) || p.Type < 0 {
+		return 0, 0, 0, 0, errors.New("only normal-size tetrominoes can be drawn")
+	}
+	t, rotation = fumenTypes[p.Type], fumenRotations[p.Rotation]
+	var cells []Offset
+	for _, c := range fumenCells(t, rotation) {
+		cells = append(cells, Offset{Row: BoardHeight - 1 - c[1], Col: c[0]})
+	}
+	dRow, dCol, ok := matchCells(p.Cells(), cells)
+	if !ok {
+		return 0, 0, 0, 0, fmt.Errorf("%s piece's shape doesn't match fumen's", p.Type)
+	}
+	x, y = dCol, -dRow
+	if i := fumenPosition(t, rotation, x, y); i < 0 || i >= fumenBlocks || x < 0 || x >= BoardWidth {
+		return 0, 0, 0, 0, errors.New("piece is outside the fumen field")
+	}
+	return t, rotation, x, y, nil
+}
+
+// pieceOfFumen returns the SRS piece for a fumen piece
+func pieceOfFumen(t, rotation, x, y int) (*Piece, error) {
+	pieceType := PieceType(slices.Index(fumenTypes[:], t))
+	p := &Piece{Type: pieceType, Rotation: RotationState(slices.Index(fumenRotations[:], rotation))}
+	var cells []Offset
+	for _, c := range fumenCells(t, rotation) {
+		cells = append(cells, Offset{Row: BoardHeight - 1 - (y + c[1]), Col: x + c[0]})
+	}
+	dRow, dCol, ok := matchCells(cells, p.Cells())
+	if !ok {
+		return nil, fmt.Errorf("%s piece's shape doesn't match fumen's", pieceType)
+	}
+	p.Row, p.Col = dRow, dCol
+	return p, nil
+}
+
+// matchCells returns how far cells must move to land on target, and
+// whether they have the same shape
+func matchCells(target, cells []Offset) (dRow, dCol int, ok bool) {
+	less := func(a, b Offset) int {
+		if a.Row != b.Row {
+			return a.Row - b.Row
+		}
+		return a.Col - b.Col
+	}
+	target = slices.SortedFunc(slices.Values(target), less)
+	cells = slices.SortedFunc(slices.Values(cells), less)
+	if len(target) != len(cells) {
+		return 0, 0, false
+	}
+	dRow, dCol = target[0].Row-cells[0].Row, target[0].Col-cells[0].Col
+	for i := range cells {
+		if cells[i].Row+dRow != target[i].Row || cells[i].Col+dCol != target[i].Col {
+			return 0, 0, false
+		}
+	}
+	return dRow, dCol, true
+}
+
+// fumenWriter builds fumen data from 6-bit values
+type fumenWriter []int
+
+// push appends a value as n base64 digits, least significant first
+func (w *fumenWriter) push(value, n int) {
+	for range n {
+		*w = append(*w, value%64)
+		value /= 64
+	}
+}
+
+// fumenReader reads values from fumen data
+type fumenReader struct {
+	data string
+	pos  int
+}
+
+// poll reads a value of n base64 digits
+func (r *fumenReader) poll(n int) (int, error) {
+	value, scale := 0, 1
+	for range n {
+		if r.pos >= len(r.data) {
+			return 0, fmt.Errorf("%w: data ends early", ErrFumen)
+		}
+		digit := strings.IndexByte(fumenTable, r.data[r.pos])
+		if digit < 0 {
+			return 0, fmt.Errorf("%w: unexpected %q", ErrFumen, r.data[r.pos])
+		}
+		value += digit * scale
+		scale *= 64
+		r.pos++
+	}
+	return value, nil
+}
+
+// EncodeFumen encodes pages as a v115 fumen. Pieces are drawn by their
+// cells, so they must be normal-size tetrominoes
+func EncodeFumen(pages []FumenPage) (string, error) {
+	var w fumenWriter
+	var prev fumenField
+	lastRepeat := -1
+	prevComment := ""
+	for i, page := range pages {
+		current := prev
+		if page.Board != nil {
+			current = fumenFieldOf(page.Board, page.Garbage)
+		}
+
+		// The field as differences from the last page's, run-length
+		// encoded. An unchanged field, a single run of 8 over every
+		// cell, starts or extends a repeat count
+		var field fumenWriter
+		changed := current != prev
+		diff := func(j int) int { return current[j] - prev[j] + 8 }
+		run, count := diff(0), -1
+		for j := range fumenBlocks {
+			if d := diff(j); d != run {
+				field.push(run*fumenBlocks+count, 2)
+				run, count = d, 0
+			} else {
+				count++
+			}
+		}
+		field.push(run*fumenBlocks+count, 2)
+		switch {
+		case changed:
+			w = append(w, field...)
+			lastRepeat = -1
+		case lastRepeat < 0 || w[lastRepeat] == 63:
+			w = append(w, field...)
+			w.push(0, 1)
+			lastRepeat = len(w) - 1
+		default:
+			w[lastRepeat]++
+		}
+
+		t, rotation, x, y := 0, 0, 0, 22
+		if page.Piece != nil {
+			var err error
+			if t, rotation, x, y, err = fumenPiece(page.Piece); err != nil {
+				return "", fmt.Errorf("page %d: %w", i+1, err)
+			}
+		}
+		comment := page.Comment != prevComment
+		prevComment = page.Comment
+		action := 0
+		for _, flag := range []bool{!page.Lock, comment, true, page.Mirror, page.Rise} {
+			action *= 2
+			if flag {
+				action++
+			}
+		}
+		action = ((action*fumenBlocks+fumenPosition(t, rotation, x, y))*4+rotation)*8 + t
+		w.push(action, 3)
+
+		if comment {
+			escaped := fumenComment(page.Comment)
+			w.push(len(escaped), 2)
+			for j := 0; j < len(escaped); j += 4 {
+				value, scale := 0, 1
+				for _, c := range []byte(escaped[j:min(j+4, len(escaped))]) {
+					value += strings.IndexByte(fumenChars, c) * scale
+					scale *= len(fumenChars) + 1
+				}
+				w.push(value, 5)
+			}
+		}
+
+		if page.Lock {
+			if t != 0 {
+				current.fill(t, rotation, x, y)
+			}
+			current.clearLines()
+			if page.Rise {
+				current.rise()
+			}
+			if page.Mirror {
+				current.mirror()
+			}
+		}
+		prev = current
+	}
+
+	var data strings.Builder
+	for _, value := range w {
+		data.WriteByte(fumenTable[value])
+	}
+	return fumenPrefix + fumenSplit(data.String()), nil
+}
+
+// fumenSplit inserts the ? fumen puts every 47 characters, counting the
+// 5-character prefix in the first stretch
+func fumenSplit(data string) string {
+	if len(data) <= 42 {
+		return data
+	}
+	parts := []string{data[:42]}
+	for rest := data[42:]; len(rest) > 0; {
+		n := min(len(rest), 47)
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+	return strings.Join(parts, "?")
+}
+
+// DecodeFumen decodes a v115 fumen, given alone or as a URL. Every page
+// has its whole stack, with the comment carried over from earlier pages
+// Stacks higher than the board are refused
+func DecodeFumen(text string) ([]FumenPage, error) {
+	start := strings.Index(text, "115@")
+	if start < 1 || !strings.ContainsRune("vmdD", rune(text[start-1])) {
+		return nil, ErrFumen
+	}
+	data := text[start+4:]
+	if end := strings.IndexByte(data, '&'); end >= 0 {
+		data = data[:end]
+	}
+	data = strings.Map(func(r rune) rune {
+		if r == '?' || r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, data)
+
+	r := &fumenReader{data: data}
+	var pages []FumenPage
+	var prev fumenField
+	repeat := 0
+	comment := ""
+	for r.pos < len(r.data) {
+		n := len(pages) + 1
+		current := prev
+		if repeat > 0 {
+			repeat--
+		} else {
+			changed := true
+			for j := 0; j < fumenBlocks; {
+				value, err := r.poll(2)
+				if err != nil {
+					return nil, err
+				}
+				diff, count := value/fumenBlocks, value%fumenBlocks+1
+				if diff == 8 && count == fumenBlocks {
+					changed = false
+				}
+				if j+count > fumenBlocks {
+					return nil, fmt.Errorf("%w: page %d's field is too long", ErrFumen, n)
+				}
+				for ; count > 0; count-- {
+					current[j] += diff - 8
+					j++
+				}
+			}
+			if !changed {
+				var err error
+				if repeat, err = r.poll(1); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		action, err := r.poll(3)
+		if err != nil {
+			return nil, err
+		}
+		t := action % 8
+		action /= 8
+		rotation := action % 4
+		action /= 4
+		x, y := fumenOrigin(t, rotation, action%fumenBlocks)
+		action /= fumenBlocks
+		page := FumenPage{
+			Rise:   action&1 != 0,
+			Mirror: action&2 != 0,
+			Lock:   action&16 == 0,
+		}
+		if action&8 != 0 {
+			length, err := r.poll(2)
+			if err != nil {
+				return nil, err
+			}
+			var escaped []byte
+			for j := 0; j < length; j += 4 {
+				value, err := r.poll(5)
+				if err != nil {
+					return nil, err
+				}
+				for range 4 {
+					c := value % (len(fumenChars) + 1)
+					if c >= len(fumenChars) {
+						return nil, fmt.Errorf("%w: page %d's comment is not valid", ErrFumen, n)
+					}
+					escaped = append(escaped, fumenChars[c])
+					value /= len(fumenChars) + 1
+				}
+			}
+			comment = jsUnescape(string(escaped[:length]))
+		}
+		page.Comment = comment
+
+		board, garbage, err := current.board()
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", n, err)
+		}
+		page.Board, page.Garbage = board, garbage
+		if t != 0 {
+			if page.Piece, err = pieceOfFumen(t, rotation, x, y); err != nil {
+				return nil, fmt.Errorf("page %d: %w", n, err)
+			}
+		}
+		pages = append(pages, page)
+
+		if page.Lock {
+			if t != 0 {
+				current.fill(t, rotation, x, y)
+			}
+			current.clearLines()
+			if page.Rise {
+				current.rise()
+			}
+			if page.Mirror {
+				current.mirror()
+			}
+		}
+		prev = current
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrFumen)
+	}
+	return pages, nil
+}
+
+// jsEscape escapes text as JavaScript's escape does, which is how fumen
+// stores comments: %XX or %uXXXX for each UTF-16 unit outside
+// A-Z a-z 0-9 @*_+-./
+func jsEscape(text string) string {
+	var b strings.Builder
+	for _, unit := range utf16.Encode([]rune(text)) {
+		switch {
+		case unit < 128 && (unit >= 'A' && unit <= 'Z' || unit >= 'a' && unit <= 'z' ||
+			unit >= '0' && unit <= '9' || strings.ContainsRune("@*_+-./", rune(unit))):
+			b.WriteByte(byte(unit))
+		case unit < 256:
+			fmt.Fprintf(&b, "%%%02X", unit)
+		default:
+			fmt.Fprintf(&b, "%%u%04X", unit)
+		}
+	}
+	return b.String()
+}
+
+// fumenComment escapes a comment with jsEscape, cut to the whole
+// characters that fit in fumenCommentMax so no escape is split
+func fumenComment(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		escaped := jsEscape(string(r))
+		if b.Len()+len(escaped) > fumenCommentMax {
+			break
+		}
+		b.WriteString(escaped)
+	}
+	return b.String()
+}
+
+// jsUnescape reverses jsEscape. Malformed escapes are kept as they are
+func jsUnescape(text string) string {
+	var units []uint16
+	for i := 0; i < len(text); i++ {
+		if text[i] == '%' {
+			if i+6 <= len(text) && text[i+1] == 'u' {
+				if unit, err := strconv.ParseUint(text[i+2:i+6], 16, 16); err == nil {
+					units = append(units, uint16(unit))
+					i += 5
+					continue
+				}
+			}
+			if i+3 <= len(text) {
+				if unit, err := strconv.ParseUint(text[i+1:i+3], 16, 8); err == nil {
+					units = append(units, uint16(unit))
+					i += 2
+					continue
+				}
+			}
+		}
+		units = append(units, uint16(text[i]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// PositionFumen encodes a position as a one-page fumen. Its queue and
+// hold go in the comment as a fumen quiz: #Q=[hold](current)next
+func PositionFumen(pos Position) (string, error) {
+	page := FumenPage{Board: pos.Board, Lock: true}
+	if len(pos.Queue) > 0 || pos.HasHold {
+		var quiz strings.Builder
+		quiz.WriteString("#Q=[")
+		if pos.HasHold {
+			quiz.WriteString(pos.Hold.String())
+		}
+		quiz.WriteString("](")
+		for i, t := range pos.Queue {
+			quiz.WriteString(t.String())
+			if i == 0 {
+				quiz.WriteString(")")
+			}
+		}
+		if len(pos.Queue) == 0 {
+			quiz.WriteString(")")
+		}
+		page.Comment = quiz.String()
+	}
+	return EncodeFumen([]FumenPage{page})
+}
+
+// FumenPosition returns the position on a fumen page: its stack, with
+// the queue and hold from a quiz comment, or else the page's piece as
+// the only piece queued
+func FumenPosition(page FumenPage) (Position, error) {
+	board := *page.Board
+	pos := Position{Board: &board}
+	quiz, ok := strings.CutPrefix(page.Comment, "#Q=")
+	if !ok {
+		if page.Piece != nil {
+			pos.Queue = []PieceType{page.Piece.Type}
+		}
+		return pos, nil
+	}
+
+	// [hold](current)next, where hold and current may be empty
+	hold, rest, ok1 := strings.Cut(strings.TrimPrefix(quiz, "["), "]")
+	current, next, ok2 := strings.Cut(strings.TrimPrefix(rest, "("), ")")
+	if !strings.HasPrefix(quiz, "[") || !strings.HasPrefix(rest, "(") || !ok1 || !ok2 || len(hold) > 1 || len(current) > 1 {
+		return Position{}, fmt.Errorf("quiz comment %q is not valid", page.Comment)
+	}
+	next, _, _ = strings.Cut(next, " ")
+	pieces, err := parsePieceLetters(current + next)
+	if err != nil {
+		return Position{}, err
+	}
+	if len(pieces) > MaxPositionQueue {
+		pieces = pieces[:MaxPositionQueue]
+	}
+	pos.Queue = pieces
+	if hold != "" {
+		held, err := parsePieceLetters(hold)
+		if err != nil {
+			return Position{}, err
+		}
+		pos.Hold, pos.HasHold = held[0], true
+	}
+	return pos, nil
+}
+
+// parsePieceLetters parses tetromino names such as "TIOL"
+func parsePieceLetters(letters string) ([]PieceType, error) {
+	pieces := make([]PieceType, 0, len(letters))
+	for _, letter := range letters {
+		i := strings.IndexRune("IOTSZJL", letter)
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not a tetromino", letter)
+		}
+		pieces = append(pieces, PieceType(i))
+	}
+	return pieces, nil
+}
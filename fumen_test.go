@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDecodeFumenKnown(t *testing.T) {
+	tests := []struct {
+		name    string
+		fumen   string
+		boards  []string // Each page's stack in board notation
+		piece   []PieceType
+		comment string
+	}{
+		{
+			name:   "empty page",
+			fumen:  "v115@vhAAgH",
+			boards: []string{"__________"},
+			piece:  []PieceType{0},
+		},
+		{
+			name:   "T then an empty page",
+			fumen:  "v115@vhBVQJAgH",
+			boards: []string{"__________", "____T_____\n___TTT____"},
+			piece:  []PieceType{PieceT, 0},
+		},
+		{
+			name:    "comment",
+			fumen:   "v115@vhAAgWBABBAAA",
+			boards:  []string{"__________"},
+			piece:   []PieceType{0},
+			comment: "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := DecodeFumen(tt.fumen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pages) != len(tt.boards) {
+				t.Fatalf("%d pages, want %d", len(pages), len(tt.boards))
+			}
+			for i, page := range pages {
+				if got, want := FormatBoard(page.Board), FormatBoard(mustBoard(t, tt.boards[i])); got != want {
+					t.Errorf("page %d board:\n%swant:\n%s", i+1, got, want)
+				}
+				if tt.piece[i] == 0 && page.Piece != nil || tt.piece[i] != 0 && (page.Piece == nil || page.Piece.Type != tt.piece[i]) {
+					t.Errorf("page %d piece %v, want %v", i+1, page.Piece, tt.piece[i])
+				}
+				if page.Comment != tt.comment {
+					t.Errorf("page %d comment %q, want %q", i+1, page.Comment, tt.comment)
+				}
+			}
+
+			encoded, err := EncodeFumen(pages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded != tt.fumen {
+				t.Errorf("encoded as %s, want %s", encoded, tt.fumen)
+			}
+		})
+	}
+}
+
+func TestFumenRoundTrip(t *testing.T) {
+	first := mustBoard(t, `
+		__________
+		ZZ___S____
+		#ZZ_SS_###
+		#_##S#####
+	`)
+	second := mustBoard(t, `
+		I_________
+		I_________
+		I____OO___
+		I____OO___
+	`)
+	var pages []FumenPage
+	pages = append(pages, FumenPage{Board: first, Comment: "dig"})
+	// More identical pages than one repeat count holds
+	for range 70 {
+		pages = append(pages, FumenPage{Board: first, Comment: "dig"})
+	}
+	garbage := [BoardWidth]CellColor{}
+	for col := range BoardWidth - 1 {
+		garbage[col] = ColorGarbage
+	}
+	pages = append(pages, FumenPage{Board: second, Garbage: garbage, Comment: "I then O ✓", Lock: true, Rise: true})
+
+	fumen, err := EncodeFumen(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeFumen(fumen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(pages) {
+		t.Fatalf("%d pages, want %d", len(decoded), len(pages))
+	}
+	for i, page := range pages {
+		got := decoded[i]
+		if FormatBoard(got.Board) != FormatBoard(page.Board) || got.Garbage != page.Garbage {
+			t.Errorf("page %d stack:\n%swant:\n%s", i+1, FormatBoard(got.Board), FormatBoard(page.Board))
+		}
+		if got.Comment != page.Comment || got.Lock != page.Lock || got.Rise != page.Rise {
+			t.Errorf("page %d is %+v, want %+v", i+1, got, page)
+		}
+	}
+}
+
+func TestFumenCommentTruncation(t *testing.T) {
+	// Every あ escapes to six characters, which don't divide
+	// fumenCommentMax, so a cut by length would split an escape
+	comment := strings.Repeat("あ", 1000)
+	fumen, err := EncodeFumen([]FumenPage{{Comment: comment}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := DecodeFumen(fumen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := pages[0].Comment
+	if want := fumenCommentMax / len("%u3042"); utf8.RuneCountInString(got) != want || !strings.HasPrefix(comment, got) {
+		t.Errorf("comment cut to %d characters, want %d whole ones", utf8.RuneCountInString(got), want)
+	}
+}
+
+func TestDecodeFumenMalformed(t *testing.T) {
+	for _, text := range []string{"", "v110@vhAAgH", "v115@", "v115@vh", "v115@vhAAg!"} {
+		if _, err := DecodeFumen(text); err == nil {
+			t.Errorf("DecodeFumen(%q) succeeded", text)
+		}
+	}
+}
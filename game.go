@@ -0,0 +1,790 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// Action is a single player input applied to the game
+type Action int
+
+const (
+	ActionLeft Action = iota
+	ActionRight
+	ActionSoftDrop
+	ActionHardDrop
+	ActionRotateCW
+	ActionRotateCCW
+	ActionRotate180
+	ActionHold
+	ActionDASLeft  // Shift left as far as the piece goes, as if DAS were charged
+	ActionDASRight // Shift right as far as the piece goes
+)
+
+// String returns the name of an action
+func (a Action) String() string {
+	names := []string{"Left", "Right", "Soft Drop", "Hard Drop", "CW", "CCW", "180", "Hold", "DAS Left", "DAS Right"}
+	if a < 0 || int(a) >= len(names) {
+		return "?"
+	}
+	return names[a]
+}
+
+// GameResult describes how a game ended
+type GameResult int
+
+const (
+	ResultNone    GameResult = iota // Game still in progress
+	ResultTopOut                    // Stack reached the top
+	ResultTimeUp                    // Mode time limit expired
+	ResultCleared                   // Mode line goal reached
+	ResultFailed                    // Mode goal can no longer be reached
+)
+
+// Phase is what the game is doing between pieces
+type Phase int
+
+const (
+	PhaseFalling   Phase = iota // A piece is in play
+	PhaseLineClear              // Cleared rows are shown before they collapse
+	PhaseEntry                  // Entry delay (ARE) before the next piece spawns
+)
+
+const (
+	PreviewCount     = 5  // Number of upcoming pieces shown
+	DefaultLockDelay = 30 // Frames a grounded piece waits before locking
+	MaxLockResets    = 15 // Moves/rotations that may restart the lock delay
+	InvisibleFlash   = 30 // Frames an invisible stack is shown after a line clear
+
+	// gravityUnit is one cell of fall, gravity is measured in
+	// 1/gravityUnit cells per frame so it stays integer
+	gravityUnit = 65536
+)
+
+// lineClearScores is the base score for clearing 0-4 lines at once,
+// multiplied by the current level
+var lineClearScores = [5]int{0, 100, 300, 500, 800}
+
+// Game holds the full state of one game independent of the UI
+// It advances one frame per Step and accepts inputs through Apply,
+// or Press and Release for inputs that auto-repeat while held
+type Game struct {
+	Mode     Mode
+	Board    *Board
+	Current  *Piece      // Piece in play, nil outside PhaseFalling
+	Queue    []PieceType // Upcoming pieces, next first
+	Fixed    []PieceType // Pieces dealt into the queue before the randomizer's
+	Hold     PieceType
+	HasHold  bool // Whether Hold contains a piece
+	HoldUsed bool // Hold already used for the current piece
+	Score    int
+	Level    int
+	Lines    int
+	Frame    int // Frames elapsed since the game started
+	Pieces   int // Pieces locked so far
+	TopOuts  int // Times the board was cleared instead of ending (zen)
+	Result   GameResult
+	Phase    Phase
+
+	GarbageCleared int     // Garbage lines cleared (dig mode)
+	LastSpin       Spin    // T-spin made by the last piece locked
+	Finesse        Finesse // Keys pressed against the fewest needed, see judgeFinesse
+	Target         *Piece  // Placement to make in the finesse trainer, nil otherwise
+	TargetKeys     int     // Fewest keys that make the target placement
+	OpenerStep     int     // Steps of the opener begun
+	OpenerLeft     []Piece // Placements left in the opener step
+	Deviation      *Piece  // Piece placed off the opener, ending the game
+
+	randomizer   Randomizer
+	garbage      *GarbageGenerator
+	garbageAdded int // Garbage rows pushed into the board so far
+	riseTimer    int // Frames since garbage last rose (survival)
+	riseInterval int // Current frames between rising garbage rows
+	gravityAcc   int // Accumulated fall in 1/gravityUnit cells
+	lockTimer    int // Frames spent grounded
+	lockResets   int // Lock delay resets used by the current piece
+	lowestRow    int // Lowest row reached by the current piece
+	phaseTimer   int // Frames left in a line clear or entry delay
+	heldShift    int // Direction held for auto-shift: -1 left, 1 right, 0 none
+	dasTimer     int // Frames the shift direction has been held
+	flashTimer   int // Frames left showing an invisible stack
+
+	initialRotation int  // Turn to apply as the next piece spawns (IRS), 0 if none
+	rotated         bool // The current piece's last move was a rotation
+	lastKick        int  // Index of the kick the last rotation used
+	keys            int  // Moves and rotations pressed for the current piece
+	initialHold     bool // Hold the next piece as it spawns (IHS)
+	master          masterState
+	recording       *Replay  // Inputs recorded for a replay, nil if not recording
+	history         *History // Undo history, nil unless the mode allows undo
+}
+
+// NewGame creates a game for the mode with the first piece spawned
+func NewGame(mode Mode, seed uint64) *Game {
+	return NewGameAt(mode, seed, Position{})
+}
+
+// NewGameAt creates a game that starts from a position: its stack and
+// hold, with its queue dealt before the randomizer's pieces
+func NewGameAt(mode Mode, seed uint64, pos Position) *Game {
+	g := &Game{
+		Mode:       mode,
+		Board:      NewBoard(),
+		Fixed:      slices.Clone(pos.Queue),
+		Hold:       pos.Hold,
+		HasHold:    pos.HasHold && !mode.NoHold,
+		Level:      mode.StartLevel,
+		randomizer: newRandomizer(mode.Randomizer, seed, pieceSet(mode.Pieces)),
+		master:     newMasterState(),
+	}
+	if pos.Board != nil {
+		*g.Board = *pos.Board
+	}
+	for i := 0; i < mode.previewCount(); i++ {
+		if next, ok := g.deal(); ok {
+			g.Queue = append(g.Queue, next)
+		}
+	}
+	if mode.GarbageGoal > 0 || mode.RiseInterval > 0 {
+		g.garbage = NewGarbageGenerator(seed, mode.Messiness)
+		g.riseInterval = mode.RiseInterval
+	}
+	if mode.GarbageGoal > 0 {
+		g.refillGarbage()
+	}
+	if next, ok := g.nextOrHeld(); ok {
+		g.spawn(next)
+	} else {
+		g.Result = ResultFailed
+	}
+	if mode.Trainer {
+		g.newTarget()
+	}
+	if mode.Undo {
+		g.history = newHistory(g)
+	}
+	return g
+}
+
+// Over reports whether the game has ended
+func (g *Game) Over() bool {
+	return g.Result != ResultNone
+}
+
+// StackHidden reports whether the locked blocks of an invisible game
+// are hidden: always while playing, except briefly after a line clear
+func (g *Game) StackHidden() bool {
+	return g.Mode.Invisible && g.flashTimer == 0 && !g.Over()
+}
+
+// HeldShift returns the direction pressed and not yet released: -1
+// left, 1 right, 0 none
+func (g *Game) HeldShift() int {
+	return g.heldShift
+}
+
+// TimeRemaining returns the frames left in a timed mode, or 0 if the
+// mode has no time limit
+func (g *Game) TimeRemaining() int {
+	if g.Mode.TimeLimit == 0 {
+		return 0
+	}
+	return max(g.Mode.TimeLimit-g.Frame, 0)
+}
+
+// Step advances the game by one frame: applies the mode's time limit
+// and rising garbage, then either gravity and lock delay for the piece
+// in play or the countdown to the next piece
+func (g *Game) Step() {
+	if g.Over() {
+		return
+	}
+
+	g.Frame++
+	if g.flashTimer > 0 {
+		g.flashTimer--
+	}
+	if g.Mode.TimeLimit > 0 && g.Frame >= g.Mode.TimeLimit {
+		g.Result = ResultTimeUp
+		return
+	}
+
+	if g.Mode.RiseInterval > 0 {
+		g.riseTimer++
+		if g.riseTimer >= g.riseInterval {
+			g.riseTimer = 0
+			g.riseInterval = max(g.riseInterval*riseSpeedup/100, g.Mode.RiseMinInterval)
+			g.riseGarbage()
+			if g.Over() {
+				return
+			}
+		}
+	}
+
+	// DAS keeps charging between pieces so a held direction shifts the
+	// next piece as soon as it appears
+	if g.heldShift != 0 {
+		g.dasTimer++
+	}
+
+	switch g.Phase {
+	case PhaseLineClear:
+		g.phaseTimer--
+		if g.phaseTimer <= 0 {
+			g.collapseLines()
+		}
+		return
+	case PhaseEntry:
+		g.phaseTimer--
+		if g.phaseTimer <= 0 {
+			g.spawnNext()
+		}
+		return
+	}
+
+	g.autoShift()
+
+	// Gravity: fall one row per whole cell accumulated
+	if !g.Mode.NoGravity {
+		g.gravityAcc += g.gravity()
+	}
+	for g.gravityAcc >= gravityUnit {
+		g.gravityAcc -= gravityUnit
+		if !g.tryMove(1, 0) {
+			g.gravityAcc = 0
+			break
+		}
+	}
+
+	// Lock delay only runs while the piece is resting on something
+	if g.grounded() {
+		g.lockTimer++
+		if g.lockTimer >= g.lockDelay() {
+			g.lockPiece()
+		}
+	} else {
+		g.lockTimer = 0
+	}
+}
+
+// Apply performs a player action on the current piece
+// Rotate and hold outside PhaseFalling are saved for the next piece
+// (IRS and IHS); other actions are ignored
+func (g *Game) Apply(action Action) {
+	g.record(inputApply, action)
+	g.apply(action)
+}
+
+// apply performs an action without recording it, see Apply
+func (g *Game) apply(action Action) {
+	if g.Over() {
+		return
+	}
+	if g.Phase != PhaseFalling {
+		g.bufferInitial(action)
+		return
+	}
+
+	switch action {
+	case ActionLeft, ActionRight, ActionDASLeft, ActionDASRight, ActionRotateCW, ActionRotateCCW, ActionRotate180:
+		// Keys count towards finesse even when the piece can't move
+		g.keys++
+	}
+
+	switch action {
+	case ActionLeft:
+		g.shift(-1)
+	case ActionRight:
+		g.shift(1)
+	case ActionDASLeft:
+		g.shiftToWall(-1)
+	case ActionDASRight:
+		g.shiftToWall(1)
+	case ActionSoftDrop:
+		if g.tryMove(1, 0) {
+			g.scoreSoftDrop()
+		}
+	case ActionHardDrop:
+		if g.Mode.NoHardDrop {
+			return
+		}
+		rows := g.DropDistance()
+		g.Current.Row += rows
+		if rows > 0 {
+			g.rotated = false
+		}
+		if g.Mode.Levels == LevelsGuideline {
+			g.Score += 2 * rows
+		}
+		g.lockPiece()
+		return
+	case ActionRotateCW:
+		g.rotate(1)
+	case ActionRotateCCW:
+		g.rotate(-1)
+	case ActionRotate180:
+		if !g.Mode.rotates180() {
+			return
+		}
+		g.rotate(2)
+	case ActionHold:
+		g.hold()
+	}
+	g.applyInstantGravity()
+}
+
+// Press starts holding a left or right input: the piece shifts once
+// now, then auto-shifts every frame once held for the mode's DAS
+// Other actions are applied once, as with Apply
+func (g *Game) Press(action Action) {
+	g.record(inputPress, action)
+	switch action {
+	case ActionLeft:
+		g.heldShift = -1
+	case ActionRight:
+		g.heldShift = 1
+	default:
+		g.apply(action)
+		return
+	}
+	g.dasTimer = 0
+	g.apply(action)
+}
+
+// Release stops holding an input started with Press
+func (g *Game) Release(action Action) {
+	g.record(inputRelease, action)
+	if (action == ActionLeft && g.heldShift == -1) ||
+		(action == ActionRight && g.heldShift == 1) {
+		g.heldShift = 0
+		g.dasTimer = 0
+	}
+}
+
+// DropDistance returns how many rows the current piece can fall
+// before landing
+func (g *Game) DropDistance() int {
+	if g.Current == nil {
+		return 0
+	}
+	test := *g.Current
+	rows := 0
+	for {
+		test.Row++
+		if g.Board.Collides(&test) {
+			return rows
+		}
+		rows++
+	}
+}
+
+// shift moves the current piece one column in dir if there's room
+func (g *Game) shift(dir int) {
+	if g.tryMove(0, dir) {
+		g.resetLockDelay()
+	}
+}
+
+// shiftToWall moves the piece sideways until it is blocked
+func (g *Game) shiftToWall(dir int) {
+	moved := false
+	for g.tryMove(0, dir) {
+		moved = true
+	}
+	if moved {
+		g.resetLockDelay()
+	}
+}
+
+// autoShift moves the piece every frame while a direction has been
+// held for longer than the mode's DAS
+func (g *Game) autoShift() {
+	if g.heldShift == 0 || g.Mode.DAS == 0 || g.dasTimer < g.Mode.DAS {
+		return
+	}
+	g.shift(g.heldShift)
+	g.applyInstantGravity()
+}
+
+// tryMove shifts the current piece if the destination is free
+func (g *Game) tryMove(dRow, dCol int) bool {
+	test := *g.Current
+	test.Row += dRow
+	test.Col += dCol
+	if g.Board.Collides(&test) {
+		return false
+	}
+	*g.Current = test
+	g.rotated = false
+	if test.Row > g.lowestRow {
+		// Reaching a new lowest row earns a fresh set of lock resets
+		g.lowestRow = test.Row
+		g.lockResets = 0
+		g.lockTimer = 0
+	}
+	return true
+}
+
+// rotate turns the current piece by dir quarter turns (1 = clockwise,
+// -1 = counter-clockwise, 2 = 180°)
+func (g *Game) rotate(dir int) {
+	if g.turn(dir) {
+		g.rotated = true
+		g.resetLockDelay()
+	}
+}
+
+// turn moves the current piece to its rotated state, trying each of
+// the rotation system's kicks in order
+// Returns false, leaving the piece unchanged, if none of them fit
+func (g *Game) turn(dir int) bool {
+	turned, kick, ok := turnPiece(g.Board, g.Current, dir)
+	if ok {
+		*g.Current = turned
+		g.lastKick = kick
+	}
+	return ok
+}
+
+// turnDirs are the quarter turns each rotate action makes, as passed
+// to turnPiece
+var turnDirs = [...]int{ActionRotateCW: 1, ActionRotateCCW: -1, ActionRotate180: 2}
+
+// turnPiece returns a piece turned dir quarter turns on the board and
+// the index of the kick that made it fit, or false if none did
+func turnPiece(b *Board, p *Piece, dir int) (Piece, int, bool) {
+	rs := pieceRotation(p.System, p.Type)
+	target := rs.Target(p, dir)
+	for i, kick := range rs.Kicks(b, p, target) {
+		test := *p
+		test.Rotation = target
+		test.Row += kick.Row
+		test.Col += kick.Col
+		if !b.Collides(&test) {
+			return test, i, true
+		}
+	}
+	return Piece{}, 0, false
+}
+
+// bufferInitial saves a rotate or hold pressed while no piece is in
+// play, to be applied as the next piece spawns
+func (g *Game) bufferInitial(action Action) {
+	if g.Mode.NoInitial {
+		return
+	}
+	switch action {
+	case ActionRotateCW:
+		g.initialRotation = 1
+	case ActionRotateCCW:
+		g.initialRotation = -1
+	case ActionRotate180:
+		if g.Mode.rotates180() {
+			g.initialRotation = 2
+		}
+	case ActionHold:
+		g.initialHold = !g.Mode.NoHold
+	}
+}
+
+// hold swaps the current piece with the held one, once per piece
+func (g *Game) hold() {
+	if g.HoldUsed || g.Mode.NoHold {
+		return
+	}
+	current := g.Current.Type
+	next := g.Hold
+	if !g.HasHold {
+		var ok bool
+		if next, ok = g.nextPiece(); !ok {
+			// Nothing left to swap in
+			return
+		}
+	}
+	g.spawn(next)
+	g.Hold = current
+	g.HasHold = true
+	g.HoldUsed = true
+}
+
+// grounded reports whether the current piece is resting on the stack
+// or floor
+func (g *Game) grounded() bool {
+	test := *g.Current
+	test.Row++
+	return g.Board.Collides(&test)
+}
+
+// lockDelay returns the frames a grounded piece waits before locking
+func (g *Game) lockDelay() int {
+	if g.Mode.LockDelay > 0 {
+		return g.Mode.LockDelay
+	}
+	if g.Mode.Levels == LevelsNES {
+		// The NES locks a landed piece on its next gravity drop
+		return nesFramesPerRow(g.Level)
+	}
+	return DefaultLockDelay
+}
+
+// resetLockDelay restarts the lock timer after a successful move or
+// rotation on the ground, up to MaxLockResets times per piece
+// Modes with step reset only restart it when the piece falls
+func (g *Game) resetLockDelay() {
+	if g.Mode.StepReset {
+		return
+	}
+	if g.grounded() && g.lockResets < MaxLockResets {
+		g.lockTimer = 0
+		g.lockResets++
+	}
+}
+
+// gravity returns the current fall speed in 1/gravityUnit cells per frame
+func (g *Game) gravity() int {
+	switch g.Mode.Levels {
+	case LevelsMaster:
+		return masterGravity(g.Level)
+	case LevelsNES:
+		return nesGravity(g.Level)
+	}
+	return gravityFor(g.Level)
+}
+
+// applyInstantGravity drops the piece straight to the stack at 20G,
+// so it never hangs in the air between frames
+func (g *Game) applyInstantGravity() {
+	if g.Current == nil || g.Mode.NoGravity || g.gravity() < 20*gravityUnit {
+		return
+	}
+	for g.tryMove(1, 0) {
+	}
+}
+
+// lockPiece fixes the current piece into the board and scores any
+// full rows, then starts the line clear or entry delay before the next
+// piece
+func (g *Game) lockPiece() {
+	if g.Mode.Trainer {
+		g.trainFinesse()
+		return
+	}
+	g.Pieces++
+	g.LastSpin = g.tSpin()
+	g.judgeFinesse()
+	placed := g.Current
+	if !g.Board.Lock(g.Current) {
+		g.topOut()
+		if g.Over() {
+			return
+		}
+	}
+	g.Current = nil
+
+	g.GarbageCleared += g.Board.FullGarbageRows()
+	cleared := g.Board.FullRows()
+	if cleared > 0 && g.Mode.Invisible {
+		g.flashTimer = InvisibleFlash
+	}
+	if g.Mode.Big {
+		// Big pieces fill board rows in pairs; each pair is one line
+		cleared /= BigScale
+	}
+	g.scoreLines(cleared)
+	if g.Mode.Goal != GoalNone {
+		g.checkGoal(cleared)
+	}
+	if g.Mode.Opener != nil {
+		g.checkOpener(placed)
+	}
+	if g.Mode.LineGoal > 0 && g.Lines >= g.Mode.LineGoal {
+		g.Result = ResultCleared
+		return
+	}
+	if g.Mode.GarbageGoal > 0 && g.GarbageCleared >= g.Mode.GarbageGoal {
+		g.Result = ResultCleared
+		return
+	}
+	if g.Over() {
+		return
+	}
+
+	if cleared > 0 && g.Mode.LineClearDelay > 0 {
+		// Leave the full rows on screen until the delay runs out
+		g.Phase = PhaseLineClear
+		g.phaseTimer = g.Mode.LineClearDelay
+		return
+	}
+	g.collapseLines()
+}
+
+// scoreLines awards points and advances lines and level for a lock
+// that completed the given number of rows
+func (g *Game) scoreLines(cleared int) {
+	switch g.Mode.Levels {
+	case LevelsMaster:
+		g.scoreMaster(cleared)
+		return
+	case LevelsNES:
+		g.scoreNES(cleared)
+		return
+	}
+	g.Score += lineClearScores[cleared] * g.Level
+	g.Lines += cleared
+	g.Level = g.Mode.StartLevel + g.Lines/10
+}
+
+// scoreSoftDrop awards points for one row of soft drop
+func (g *Game) scoreSoftDrop() {
+	if g.Mode.Levels == LevelsMaster {
+		g.master.softRows++
+		return
+	}
+	g.Score++
+}
+
+// collapseLines removes the cleared rows, tops up dig garbage and
+// starts the entry delay
+func (g *Game) collapseLines() {
+	g.Board.ClearLines()
+	if g.Mode.GarbageGoal > 0 {
+		g.refillGarbage()
+		if g.Over() {
+			return
+		}
+	}
+
+	if g.Mode.ARE > 0 {
+		g.Phase = PhaseEntry
+		g.phaseTimer = g.Mode.ARE
+		return
+	}
+	g.spawnNext()
+}
+
+// spawnNext brings the next piece from the queue into play
+func (g *Game) spawnNext() {
+	if g.Mode.Levels == LevelsMaster {
+		g.advanceMasterLevel(1, false)
+	}
+	g.HoldUsed = false
+	next, ok := g.nextOrHeld()
+	if !ok {
+		// Only the position's pieces are dealt, and they've run out
+		g.Result = ResultFailed
+		return
+	}
+
+	// Initial hold: the new piece goes straight into hold and the held
+	// one (or the one after it) spawns instead. With nothing held and
+	// nothing left to deal there is no piece to swap in
+	if g.initialHold {
+		g.initialHold = false
+		if g.HasHold {
+			next, g.Hold = g.Hold, next
+			g.HoldUsed = true
+		} else if after, ok := g.nextPiece(); ok {
+			next, g.Hold = after, next
+			g.HasHold = true
+			g.HoldUsed = true
+		}
+	}
+	g.spawn(next)
+	if g.history != nil && !g.Over() {
+		g.history.placed(g)
+	}
+}
+
+// nextOrHeld takes the next piece from the queue, or the held piece
+// once a queue of only the position's pieces has run out
+func (g *Game) nextOrHeld() (PieceType, bool) {
+	if next, ok := g.nextPiece(); ok || !g.HasHold {
+		return next, ok
+	}
+	g.HasHold = false
+	return g.Hold, true
+}
+
+// nextPiece takes the front of the preview queue and refills it
+// Returns false if the queue has run out, which only happens in modes
+// without random pieces
+func (g *Game) nextPiece() (PieceType, bool) {
+	if len(g.Queue) == 0 {
+		return 0, false
+	}
+	next := g.Queue[0]
+	g.Queue = g.Queue[1:]
+	if piece, ok := g.deal(); ok {
+		g.Queue = append(g.Queue, piece)
+	}
+	return next, true
+}
+
+// deal returns the next piece for the queue: the next fixed piece if
+// there are any left, otherwise the randomizer's. Returns false once
+// the fixed pieces run out in modes without random pieces
+func (g *Game) deal() (PieceType, bool) {
+	if len(g.Fixed) > 0 {
+		next := g.Fixed[0]
+		g.Fixed = g.Fixed[1:]
+		return next, true
+	}
+	if g.Mode.NoRandom {
+		return 0, false
+	}
+	return g.randomizer.Next(), true
+}
+
+// spawn places a new piece of the given type at the top of the board,
+// turned first if a rotation was buffered (initial rotation). A turn
+// that doesn't fit anywhere the kicks allow leaves the piece in its
+// spawn state. If it then overlaps the stack the game is over (block out)
+func (g *Game) spawn(pieceType PieceType) {
+	g.Phase = PhaseFalling
+	g.Current = g.Mode.spawnPiece(pieceType)
+	g.gravityAcc = 0
+	g.lockTimer = 0
+	g.lockResets = 0
+	g.rotated = false
+	g.keys = 0
+	if g.Mode.Opener != nil {
+		g.aimOpener()
+	}
+	if g.initialRotation != 0 {
+		g.turn(g.initialRotation)
+		g.initialRotation = 0
+	}
+	g.lowestRow = g.Current.Row
+	g.master.softRows = 0
+	if g.Board.Collides(g.Current) {
+		g.topOut()
+		return
+	}
+
+	// A direction held through the entry delay shifts the new piece
+	// straight away
+	if g.Mode.DAS > 0 && g.heldShift != 0 && g.dasTimer >= g.Mode.DAS {
+		g.autoShift()
+	}
+	g.applyInstantGravity()
+}
+
+// topOut ends the game when the stack reaches the top, or in modes
+// without game over clears the board so play can continue
+func (g *Game) topOut() {
+	if !g.Mode.NoTopOut {
+		g.Result = ResultTopOut
+		return
+	}
+	g.Board = NewBoard()
+	g.TopOuts++
+}
+
+// gravityFor returns the fall speed for a level in 1/gravityUnit cells
+// per frame, following the guideline curve and capped at 20G
+func gravityFor(level int) int {
+	level = min(max(level, 1), 20)
+	seconds := math.Pow(0.8-float64(level-1)*0.007, float64(level-1))
+	gravity := int(gravityUnit / (seconds * FramesPerSecond))
+	return min(gravity, 20*gravityUnit)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+)
+
+const (
+	// DefaultMessiness makes every garbage row's hole move, like a
+	// classic cheese race
+	DefaultMessiness = 1.0
+
+	// riseSpeedup is the percentage of the previous interval the next
+	// rising garbage row waits in survival
+	riseSpeedup = 95
+)
+
+// GarbageGenerator picks the hole column for each garbage row
+// Messiness is the probability (0-1) that a new row's hole moves to a
+// different column than the row before it: 0 gives a single straight
+// well, 1 moves the hole on every row
+type GarbageGenerator struct {
+	Messiness float64
+
+	src      *rand.PCG
+	rng      *rand.Rand
+	lastHole int
+	started  bool
+}
+
+// NewGarbageGenerator creates a generator seeded with the given value
+// It uses its own random stream so garbage never changes the piece queue
+func NewGarbageGenerator(seed uint64, messiness float64) *GarbageGenerator {
+	src := rand.NewPCG(seed, seed^0xc2b2ae3d27d4eb4f)
+	return &GarbageGenerator{
+		Messiness: min(max(messiness, 0), 1),
+		src:       src,
+		rng:       rand.New(src),
+	}
+}
+
+// NextHole returns the hole column for the next garbage row
+func (gg *GarbageGenerator) NextHole() int {
+	if !gg.started {
+		gg.started = true
+		gg.lastHole = gg.rng.IntN(BoardWidth)
+		return gg.lastHole
+	}
+	if gg.rng.Float64() < gg.Messiness {
+		// Move to any other column
+		gg.lastHole = (gg.lastHole + 1 + gg.rng.IntN(BoardWidth-1)) % BoardWidth
+	}
+	return gg.lastHole
+}
+
+// garbageJSON is the saved form of a GarbageGenerator
+type garbageJSON struct {
+	RNG       []byte  `json:"rng"`
+	Messiness float64 `json:"messiness"`
+	LastHole  int     `json:"last_hole"`
+	Started   bool    `json:"started"`
+}
+
+// MarshalJSON saves the random generator state and the last hole so a
+// restored generator makes exactly the same garbage
+func (gg *GarbageGenerator) MarshalJSON() ([]byte, error) {
+	rng, err := gg.src.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(garbageJSON{
+		RNG:       rng,
+		Messiness: gg.Messiness,
+		LastHole:  gg.lastHole,
+		Started:   gg.started,
+	})
+}
+
+// UnmarshalJSON restores a generator saved with MarshalJSON
+func (gg *GarbageGenerator) UnmarshalJSON(data []byte) error {
+	var saved garbageJSON
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	src := &rand.PCG{}
+	if err := src.UnmarshalBinary(saved.RNG); err != nil {
+		return err
+	}
+	gg.Messiness = saved.Messiness
+	gg.src = src
+	gg.rng = rand.New(src)
+	gg.lastHole = saved.LastHole
+	gg.started = saved.Started
+	return nil
+}
+
+// addGarbage pushes rows of garbage into the bottom of the board
+// Returns false if the stack was pushed off the top
+func (g *Game) addGarbage(rows int) bool {
+	for i := 0; i < rows; i++ {
+		if !g.Board.AddGarbageRow(g.garbage.NextHole()) {
+			return false
+		}
+		g.garbageAdded++
+	}
+	return true
+}
+
+// refillGarbage tops the dig mode garbage back up to the mode's
+// height, without adding more than the goal in total
+func (g *Game) refillGarbage() {
+	onBoard := g.garbageAdded - g.GarbageCleared
+	remaining := g.Mode.GarbageGoal - g.garbageAdded
+	if !g.addGarbage(min(g.Mode.GarbageHeight-onBoard, remaining)) {
+		g.topOut()
+	}
+}
+
+// RiseTimeRemaining returns the frames until the next garbage row
+// rises, or 0 if the mode has no rising garbage
+func (g *Game) RiseTimeRemaining() int {
+	if g.Mode.RiseInterval == 0 {
+		return 0
+	}
+	return g.riseInterval - g.riseTimer
+}
+
+// riseGarbage pushes one garbage row into the bottom of the board
+// The active piece stays where it is unless the rising stack would
+// overlap it, in which case it is pushed up with the stack. The game
+// ends if the stack or the pushed piece goes above the top row
+func (g *Game) riseGarbage() {
+	if !g.addGarbage(1) {
+		g.topOut()
+		return
+	}
+	if g.Current == nil || !g.Board.Collides(g.Current) {
+		return
+	}
+
+	// The stack moved up exactly one row, so moving the piece up one
+	// row restores the non-overlapping position it had before
+	g.Current.Row--
+	g.lowestRow--
+	for _, cell := range g.Current.Cells() {
+		if cell.Row < 0 {
+			g.topOut()
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxHighScores is how many entries are kept per mode
+const MaxHighScores = 10
+
+// Ranking decides how a mode's high score table is ordered
+type Ranking int
+
+const (
+	RankScore   Ranking = iota // Highest score first
+	RankFastest                // Shortest time first, for races to a goal
+	RankLongest                // Longest time first, for survival
+)
+
+// HighScore is one finished game in the high score table
+type HighScore struct {
+	Score      int       `json:"score"`
+	Lines      int       `json:"lines"`
+	Level      int       `json:"level"`
+	StartLevel int       `json:"start_level"`
+	Frames     int       `json:"frames"`
+	Cleared    bool      `json:"cleared"`         // Reached the mode's goal
+	Grade      string    `json:"grade,omitempty"` // Master grade
+	Date       time.Time `json:"date"`
+}
+
+// HighScores holds the ranked tables for every mode, keyed by Mode.Key
+type HighScores map[string][]HighScore
+
+// dataPath returns the path of a file in the gotetris data directory
+// under the user's config directory
+func dataPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gotetris", name), nil
+}
+
+// LoadHighScores reads the high score file
+// A missing file is not an error and yields empty tables
+func LoadHighScores(path string) (HighScores, error) {
+	scores := HighScores{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return scores, nil
+	}
+	if err != nil {
+		return scores, err
+	}
+	if err := json.Unmarshal(data, &scores); err != nil {
+		return HighScores{}, err
+	}
+	return scores, nil
+}
+
+// Save writes the high score file, creating its directory if needed
+func (h HighScores) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Add inserts an entry into the table for key, keeping it sorted by
+// the given ranking and trimmed to MaxHighScores
+// Returns the 0-based rank of the new entry, or -1 if it didn't place
+func (h HighScores) Add(key string, entry HighScore, ranking Ranking) int {
+	table := append(h[key], entry)
+	// Stable sort keeps earlier entries ahead of equal later scores
+	sort.SliceStable(table, func(i, j int) bool {
+		switch ranking {
+		case RankFastest:
+			return table[i].Frames < table[j].Frames
+		case RankLongest:
+			return table[i].Frames > table[j].Frames
+		default:
+			return table[i].Score > table[j].Score
+		}
+	})
+
+	rank := -1
+	for i := range table {
+		if table[i] == entry {
+			rank = i
+			break
+		}
+	}
+
+	if len(table) > MaxHighScores {
+		table = table[:MaxHighScores]
+	}
+	if rank >= MaxHighScores {
+		rank = -1
+	}
+	h[key] = table
+	return rank
+}
@@ -0,0 +1,148 @@
+package main
+
+// MaxUndo is how many placements a practice game can undo
+const MaxUndo = 1000
+
+// History is the undo tree of a game played with Mode.Undo
+// Each node is the whole game encoded as it was when a piece spawned,
+// random generators included, so undoing a placement brings back the
+// same piece and the same queue behind it. Placing a piece after an
+// undo starts a new branch beside the undone placements, and switching
+// branches goes back to them
+type History struct {
+	current *historyNode // The game when the piece in play spawned
+	depth   int          // Placements that can be undone
+}
+
+// historyNode is one snapshot in the undo tree
+type historyNode struct {
+	data     []byte
+	parent   *historyNode   // Snapshot before the last placement, nil at the root
+	children []*historyNode // Branches placed from here, oldest first
+	redo     int            // Index of the child Redo returns to
+}
+
+// newHistory creates an empty history starting from the game's
+// current piece
+func newHistory(g *Game) *History {
+	data, _ := encodeGame(g)
+	return &History{current: &historyNode{data: data}}
+}
+
+// placed records that a piece was locked and the next one spawned
+func (h *History) placed(g *Game) {
+	data, err := encodeGame(g)
+	if err != nil {
+		return
+	}
+	node := &historyNode{data: data, parent: h.current}
+	h.current.children = append(h.current.children, node)
+	h.current.redo = len(h.current.children) - 1
+	h.current = node
+	h.depth++
+	if h.depth > MaxUndo {
+		// Forget the oldest snapshot, and the branches placed from it
+		root := h.current
+		for range MaxUndo {
+			root = root.parent
+		}
+		root.parent = nil
+		h.depth = MaxUndo
+	}
+}
+
+// redoDepth returns how many undone placements Redo can put back
+func (h *History) redoDepth() int {
+	n := 0
+	for node := h.current; len(node.children) > 0; node = node.children[node.redo] {
+		n++
+	}
+	return n
+}
+
+// branch returns the current branch's number and the number of
+// branches placed from the previous snapshot, both 1 at the root
+func (h *History) branch() (int, int) {
+	parent := h.current.parent
+	if parent == nil {
+		return 1, 1
+	}
+	return parent.redo + 1, len(parent.children)
+}
+
+// CanUndo reports whether there is a placement to undo
+func (g *Game) CanUndo() bool {
+	return g.history != nil && g.history.current.parent != nil
+}
+
+// CanRedo reports whether there is an undone placement to redo
+func (g *Game) CanRedo() bool {
+	return g.history != nil && len(g.history.current.children) > 0
+}
+
+// Undo takes back the last placement, returning to when the piece
+// spawned. It reports whether there was one to undo
+func (g *Game) Undo() bool {
+	if !g.CanUndo() {
+		return false
+	}
+	h := g.history
+	if !g.restore(h.current.parent.data) {
+		return false
+	}
+	h.current = h.current.parent
+	h.depth--
+	return true
+}
+
+// Redo puts back the last undone placement on the current branch. It
+// reports whether there was one to redo
+func (g *Game) Redo() bool {
+	if !g.CanRedo() {
+		return false
+	}
+	h := g.history
+	next := h.current.children[h.current.redo]
+	if !g.restore(next.data) {
+		return false
+	}
+	h.current = next
+	h.depth++
+	return true
+}
+
+// CanSwitchBranch reports whether the last placement has another
+// branch beside it
+func (g *Game) CanSwitchBranch() bool {
+	return g.history != nil && g.history.current.parent != nil && len(g.history.current.parent.children) > 1
+}
+
+// SwitchBranch replaces the last placement with the next branch placed
+// from the same snapshot, cycling back to the first after the last. It
+// reports whether there was another branch to switch to
+func (g *Game) SwitchBranch() bool {
+	if !g.CanSwitchBranch() {
+		return false
+	}
+	h := g.history
+	parent := h.current.parent
+	next := (parent.redo + 1) % len(parent.children)
+	if !g.restore(parent.children[next].data) {
+		return false
+	}
+	parent.redo = next
+	h.current = parent.children[next]
+	return true
+}
+
+// restore replaces the game with an encoded snapshot of it, keeping
+// its history
+func (g *Game) restore(data []byte) bool {
+	restored, err := decodeGame(data)
+	if err != nil {
+		return false
+	}
+	restored.history = g.history
+	*g = *restored
+	return true
+}
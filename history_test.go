@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// placeAt hard drops the piece in play after shifting it to the wall
+func placeAt(g *Game, shift Action) string {
+	g.Apply(shift)
+	g.Apply(ActionHardDrop)
+	return FormatBoard(g.Board)
+}
+
+func TestHistoryBranches(t *testing.T) {
+	g := NewGame(NewPracticeMode(false), 3)
+	first := placeAt(g, ActionDASLeft)
+	left := placeAt(g, ActionDASLeft)
+
+	if !g.Undo() || FormatBoard(g.Board) != first {
+		t.Fatal("undo didn't return to the first placement")
+	}
+	right := placeAt(g, ActionDASRight)
+	if right == left {
+		t.Fatal("the new branch placed the piece where the old one did")
+	}
+	if branch, branches := g.history.branch(); branch != 2 || branches != 2 {
+		t.Errorf("on branch %d/%d, want 2/2", branch, branches)
+	}
+
+	if !g.SwitchBranch() || FormatBoard(g.Board) != left {
+		t.Fatal("switching branch didn't bring back the undone placement")
+	}
+	if !g.SwitchBranch() || FormatBoard(g.Board) != right {
+		t.Fatal("switching branch again didn't cycle back to the new one")
+	}
+
+	// Redo follows the branch last switched to
+	g.Undo()
+	if !g.Redo() || FormatBoard(g.Board) != right {
+		t.Error("redo didn't follow the current branch")
+	}
+	if g.CanRedo() {
+		t.Error("redo past the last placement")
+	}
+	if g.history.depth != 2 {
+		t.Errorf("undo depth %d, want 2", g.history.depth)
+	}
+}
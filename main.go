@@ -1,8 +1,17 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -36,22 +45,209 @@ var (
 
 	controlsStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#c0a36e"))
+
+	highlightStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#e6c384"))
+
+	dimStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#727169"))
 )
 
+// screen is the part of the app currently shown
+type screen int
+
+const (
+	screenMenu    screen = iota // Mode selection
+	screenPlaying               // Game in progress
+	screenResults               // Final score after a game ends
+	screenReplay                // Replay viewer
+	screenEditor                // Board editor
+)
+
+const (
+	ultraTimeStep = 30 * time.Second // Menu adjustment step for Ultra
+	ultraTimeMin  = 30 * time.Second
+	ultraTimeMax  = 10 * time.Minute
+
+	// keyReleaseTimeout is how long after the last press or key repeat
+	// a held key counts as released, since terminals don't report
+	// key releases
+	keyReleaseTimeout = 100 * time.Millisecond
+
+	replaySeekStep = 10 * FramesPerSecond // Frames skipped by [ and ] in the replay viewer
+
+	sidePanelWidth = 20 // Width of the stats and next panels
+)
+
+// replaySpeeds are the playback speeds offered by the replay viewer
+var replaySpeeds = []float64{0.25, 0.5, 1, 2, 4, 8}
+
+// aiSpeeds are the inputs per second the AI can be watched at, 0 for
+// every input of a piece at once
+var aiSpeeds = []int{5, 10, 20, 60, 0}
+
+// tickMsg drives the game loop at roughly FramesPerSecond
+type tickMsg time.Time
+
+// tick schedules the next game loop tick
+func tick() tea.Cmd {
+	return tea.Tick(time.Second/FramesPerSecond, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
 // Model holds our application state
 type model struct {
-	ready        bool
-	width        int
-	height       int
-	score        int
-	level        int
-	lines        int
-	board        *Board
-	currentPiece *Piece
+	ready  bool
+	width  int
+	height int
+	screen screen
+
+	// Menu
+	menuIndex       int
+	startLevel      int
+	ultraTime       time.Duration
+	digGoal         int
+	messiness       float64
+	zenGravity      bool
+	practiceGravity bool
+	nesLevel        int                    // Classic start level
+	customPieces    string                 // Name of the piece set loaded with -pieces, "" if none
+	options         map[ModeID]modeOptions // Variants chosen for each mode
+
+	// Game in progress
+	game      *Game
+	paused    bool
+	lastTick  time.Time
+	frameDebt time.Duration // Wall time not yet turned into frames
+	heldKey   string        // Shift key held for DAS, "" if none
+	heldAt    time.Time     // Last press or repeat of heldKey
+
+	// Game saved on quit, offered by Continue on the menu
+	savePath  string
+	resume    *Game // Saved game to continue, nil if none
+	resumeErr error // Error saving or loading the saved game, if any
+
+	// Zen session, auto-saved after every piece so it can be continued
+	zenPath   string
+	zenSave   *Game // Saved session to continue, nil if none
+	zenPieces int   // Pieces locked when the session was last saved
+	zenErr    error // Error saving or loading the session, if any
+
+	// High scores
+	scores     HighScores
+	scoresPath string
+	rank       int   // Rank of the last finished game, -1 if unranked
+	saveErr    error // Error saving the last result, if any
+
+	// Replays
+	lastReplay  *Replay       // Replay of the last finished game, nil if none
+	replayErr   error         // Error verifying or saving it, if any
+	replayPath  string        // File it was saved to
+	player      *ReplayPlayer // Replay being watched
+	replaySpeed int           // Index into replaySpeeds
+	replayBack  screen        // Screen the viewer returns to
+
+	// Board editor, kept while playing from its position
+	editor        *Editor
+	positionPath  string
+	positionErr   error  // Error saving or loading the position, if any
+	positionSaved bool   // The position was just saved
+	editorFumen   string // Fumen of the position, shown after exporting it
+
+	// Puzzles, the starter pack followed by the player's own
+	puzzles      []Puzzle
+	puzzleIndex  int
+	puzzleErr    error // Error loading puzzles, if any
+	progress     PuzzleProgress
+	progressPath string
+	puzzleFirst  bool // The last game solved its puzzle for the first time
+
+	// Openers, the starter pack followed by the player's own
+	openers     []*Opener
+	openerIndex int
+	openerErr   error       // Error loading openers or dealing a queue, if any
+	openerQueue []PieceType // Queue the last opener game was dealt, to retry it
+
+	// AI, watched playing a game when watch is set
+	ai      *AI
+	bot     *TBPBot // Outside bot watched instead of the AI, if given with -bot
+	watch   *AIPlayer
+	aiSpeed int // Index into aiSpeeds
+
+	// Hints: the AI's suggestion for the piece in play, shown while
+	// hints is set, and the keys that put the piece there
+	hints      bool
+	hinted     bool // Hints were shown during the game
+	hint       *Hint
+	hintKeys   []Action
+	hintPieces int   // Game.Pieces when the hint was last updated
+	hintFrom   Piece // Where the piece was then
+}
+
+// watchable reports whether the AI, or the outside bot if there is
+// one, can be watched playing the mode
+func (m model) watchable(mode Mode) bool {
+	return mode.watchable() && (m.bot == nil || tbpSupports(mode))
+}
+
+// watchedName returns the name of whoever is watched playing
+func (m model) watchedName() string {
+	if m.bot != nil {
+		return m.bot.Info.Name
+	}
+	return "AI"
+}
+
+// menuModes returns the modes offered on the title menu
+func (m model) menuModes() []Mode {
+	modes := []Mode{
+		NewMarathonMode(m.startLevel),
+		NewEndlessMode(m.startLevel),
+		NewUltraMode(m.ultraTime),
+		NewDigMode(m.digGoal, m.messiness),
+		NewSurvivalMode(m.messiness),
+		NewZenMode(m.zenGravity),
+		NewPracticeMode(m.practiceGravity),
+		NewFinesseMode(),
+		NewMasterMode(),
+		NewClassicMode(m.nesLevel),
+		NewPieceSetMode(ModePentomino, "Pentomino", PieceSetPentomino, m.startLevel),
+		NewPieceSetMode(ModeTriomino, "Tri-Tetra", PieceSetTriomino, m.startLevel),
+	}
+	if m.customPieces != "" {
+		modes = append(modes, NewPieceSetMode(ModeCustom, m.customPieces, PieceSetCustom, m.startLevel))
+	}
+	if len(m.puzzles) > 0 {
+		modes = append(modes, NewPuzzleMode(m.puzzles[m.puzzleIndex]))
+	}
+	if len(m.openers) > 0 {
+		modes = append(modes, NewOpenerMode(m.openers[m.openerIndex]))
+	}
+	for i := range modes {
+		options := m.options[modes[i].ID]
+		if options.rotation != "" {
+			modes[i].Rotation = options.rotation
+		}
+		modes[i].Big = options.big && modes[i].allowsBig()
+		modes[i].Invisible = options.invisible
+	}
+	return modes
+}
+
+// modeOptions are the variants chosen on the menu for one mode
+type modeOptions struct {
+	rotation  string // Rotation system, "" for the mode's own
+	big       bool
+	invisible bool
 }
 
 // Init is called once at startup
 func (m model) Init() tea.Cmd {
+	if m.screen == screenReplay {
+		return tick()
+	}
 	return nil
 }
 
@@ -60,82 +256,944 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
 	case tea.KeyMsg:
-		// Handle keyboard input
 		switch msg.String() {
-		case "ctrl+c", "q":
-			return m, tea.Quit
-		case "n":
-			// Cycle to next piece type
-			if m.currentPiece != nil {
-				nextType := (m.currentPiece.Type + 1) % 7
-				m.currentPiece = NewPiece(nextType, 2, 3)
-			}
-		case "p":
-			// Cycle to previous piece type
-			if m.currentPiece != nil {
-				prevType := (m.currentPiece.Type + 6) % 7 // +6 = -1 mod 7
-				m.currentPiece = NewPiece(prevType, 2, 3)
+		case "ctrl+c":
+			return m.saveProgress(), tea.Quit
+		case "ctrl+z":
+			// Save before suspending in case the process never resumes
+			m = m.saveProgress()
+			if m.screen == screenPlaying {
+				m.paused = true
 			}
+			return m, tea.Suspend
+		}
+		switch m.screen {
+		case screenMenu:
+			return m.updateMenu(msg)
+		case screenPlaying:
+			return m.updatePlaying(msg)
+		case screenResults:
+			return m.updateResults(msg)
+		case screenReplay:
+			return m.updateReplay(msg)
+		case screenEditor:
+			return m.updateEditor(msg)
+		}
+
+	case tea.MouseMsg:
+		if m.screen == screenEditor {
+			return m.updateEditorMouse(msg), nil
+		}
+
+	case tickMsg:
+		return m.updateTick(time.Time(msg))
+
+	case tea.ResumeMsg:
+		// Don't try to catch up on the time spent suspended
+		m.lastTick = time.Now()
+		m.frameDebt = 0
+
+	case tea.WindowSizeMsg:
+		// Handle terminal resize
+		m.width = msg.Width
+		m.height = msg.Height
+		m.ready = true
+	}
+
+	return m, nil
+}
+
+// updateMenu handles keys on the title menu
+func (m model) updateMenu(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	modes := m.menuModes()
+	m.watch = nil
+
+	// A saved game adds Continue above the modes, at index -1
+	first := 0
+	if m.resume != nil {
+		first = -1
+	}
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "up", "w", "k":
+		m.menuIndex--
+		if m.menuIndex < first {
+			m.menuIndex = len(modes) - 1
+		}
+		return m, nil
+	case "down", "s", "j":
+		m.menuIndex++
+		if m.menuIndex >= len(modes) {
+			m.menuIndex = first
+		}
+		return m, nil
+	}
+	if m.menuIndex < 0 {
+		switch msg.String() {
+		case "enter", " ":
+			return m.continueGame()
+		case "x":
+			m = m.discardSave()
+		}
+		return m, nil
+	}
+
+	switch msg.String() {
+	case "left", "a", "h":
+		switch modes[m.menuIndex].ID {
+		case ModeMarathon, ModeEndless, ModePentomino, ModeTriomino, ModeCustom:
+			m.startLevel = max(m.startLevel-1, 1)
+		case ModeUltra:
+			m.ultraTime = max(m.ultraTime-ultraTimeStep, ultraTimeMin)
+		case ModeDig:
+			m.digGoal = cycleOption(DigGoals, m.digGoal, -1)
+		case ModeZen:
+			m.zenGravity = !m.zenGravity
+		case ModePractice:
+			m.practiceGravity = !m.practiceGravity
+		case ModeClassic:
+			m.nesLevel = max(m.nesLevel-1, 0)
+		case ModePuzzle:
+			m.puzzleIndex = (m.puzzleIndex + len(m.puzzles) - 1) % len(m.puzzles)
+		case ModeOpener:
+			m.openerIndex = (m.openerIndex + len(m.openers) - 1) % len(m.openers)
+		}
+	case "right", "d", "l":
+		switch modes[m.menuIndex].ID {
+		case ModeMarathon, ModeEndless, ModePentomino, ModeTriomino, ModeCustom:
+			m.startLevel = min(m.startLevel+1, MaxStartLevel)
+		case ModeUltra:
+			m.ultraTime = min(m.ultraTime+ultraTimeStep, ultraTimeMax)
+		case ModeDig:
+			m.digGoal = cycleOption(DigGoals, m.digGoal, 1)
+		case ModeZen:
+			m.zenGravity = !m.zenGravity
+		case ModePractice:
+			m.practiceGravity = !m.practiceGravity
+		case ModeClassic:
+			m.nesLevel = min(m.nesLevel+1, MaxClassicStartLevel)
+		case ModePuzzle:
+			m.puzzleIndex = (m.puzzleIndex + 1) % len(m.puzzles)
+		case ModeOpener:
+			m.openerIndex = (m.openerIndex + 1) % len(m.openers)
+		}
+	case "r", "b", "i":
+		// Zen continues a saved session with the rules it started with
+		mode := modes[m.menuIndex]
+		if mode.ID == ModeZen && m.zenSave != nil {
+			break
+		}
+		options := m.options[mode.ID]
+		switch msg.String() {
 		case "r":
-			// Rotate piece clockwise
-			if m.currentPiece != nil {
-				m.currentPiece.Rotation = (m.currentPiece.Rotation + 1) % 4
+			options.rotation = cycleOption(RotationSystems, cmp.Or(mode.Rotation, RotationSRS), 1)
+		case "b":
+			options.big = mode.allowsBig() && !options.big
+		case "i":
+			options.invisible = !options.invisible
+		}
+		m.options[mode.ID] = options
+	case "n":
+		if modes[m.menuIndex].ID == ModeZen && m.zenSave != nil {
+			// Discard the saved session and start over
+			m.zenSave = nil
+			if m.zenPath != "" {
+				if err := os.Remove(m.zenPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+					m.zenErr = err
+				}
 			}
-		case "left", "h":
-			// Move piece left
-			if m.currentPiece != nil {
-				m.currentPiece.Col--
+			return m.startGame(modes[m.menuIndex])
+		}
+	case "e":
+		if modes[m.menuIndex].ID == ModePractice {
+			return m.openEditor(), nil
+		}
+	case "o":
+		if modes[m.menuIndex].ID == ModePractice {
+			pos, err := m.loadPosition()
+			if m.positionErr = err; err != nil {
+				return m, nil
 			}
-		case "right", "l":
-			// Move piece right
-			if m.currentPiece != nil {
-				m.currentPiece.Col++
+			return m.playPosition(pos)
+		}
+	case "v":
+		if m.watchable(modes[m.menuIndex]) {
+			m.editor = nil
+			var bot Bot = m.ai
+			if m.bot != nil {
+				bot = m.bot
 			}
-		case "up", "k":
-			// Move piece up
-			if m.currentPiece != nil {
-				m.currentPiece.Row--
+			m.watch = NewAIPlayer(bot)
+			return m.startGame(modes[m.menuIndex])
+		}
+	case "enter", " ":
+		m.editor = nil
+		return m.startGame(modes[m.menuIndex])
+	}
+	return m, nil
+}
+
+// cycleOption returns the option dir steps away from current in
+// options, wrapping around; an unknown current value starts at the first
+func cycleOption[T comparable](options []T, current T, dir int) T {
+	for i, option := range options {
+		if option == current {
+			return options[(i+dir+len(options))%len(options)]
+		}
+	}
+	return options[0]
+}
+
+// updatePlaying handles game controls
+func (m model) updatePlaying(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+	switch key {
+	case "q":
+		return m.saveProgress(), tea.Quit
+	case "esc":
+		// Leave the game without recording a result, back to the editor
+		// if the game was started from it
+		m = m.saveZen()
+		m.screen = screenMenu
+		if m.editor != nil {
+			m.screen = screenEditor
+		}
+		m.game = nil
+		return m, nil
+	case "p":
+		m.paused = !m.paused
+		return m, nil
+	}
+	if m.paused {
+		return m, nil
+	}
+	if m.watch != nil {
+		// The AI has the controls
+		switch key {
+		case "up":
+			m.aiSpeed = min(m.aiSpeed+1, len(aiSpeeds)-1)
+		case "down":
+			m.aiSpeed = max(m.aiSpeed-1, 0)
+		}
+		return m, nil
+	}
+
+	switch key {
+	case "a":
+		m = m.shiftKey(key, ActionLeft)
+	case "d":
+		m = m.shiftKey(key, ActionRight)
+	case "A":
+		m.game.Apply(ActionDASLeft)
+	case "D":
+		m.game.Apply(ActionDASRight)
+	case "s":
+		m.game.Apply(ActionSoftDrop)
+	case "w", " ":
+		m.game.Apply(ActionHardDrop)
+	case "right":
+		m.game.Apply(ActionRotateCW)
+	case "left":
+		m.game.Apply(ActionRotateCCW)
+	case "up":
+		m.game.Apply(ActionRotate180)
+	case "c":
+		m.game.Apply(ActionHold)
+	case "z":
+		m = m.releaseHeldKey()
+		m.game.Undo()
+	case "y":
+		m = m.releaseHeldKey()
+		m.game.Redo()
+	case "b":
+		m = m.releaseHeldKey()
+		m.game.SwitchBranch()
+	case "h":
+		m.hints = !m.hints
+	}
+
+	if m.game.Over() {
+		return m.finishGame(), nil
+	}
+	return m.updateHint().autosaveZen(), nil
+}
+
+// updateHint finds the AI's suggestion for the piece in play while
+// hints are shown, and the keys that put the piece there from where it
+// is. A new suggestion is only needed for a new piece, or one moved
+// where the last can't be reached from
+func (m model) updateHint() model {
+	g := m.game
+	if !m.hints || m.watch != nil || g.Over() || g.Current == nil || !g.Mode.hintable() {
+		m.hint, m.hintKeys = nil, nil
+		return m
+	}
+	m.hinted = true
+	if g.Pieces == m.hintPieces && *g.Current == m.hintFrom {
+		return m
+	}
+	m.hintPieces, m.hintFrom = g.Pieces, *g.Current
+
+	if m.hint == nil || !m.hint.For(g) {
+		m.hint = NewHint(m.ai, g)
+	}
+	m.hintKeys = nil
+	if m.hint == nil {
+		return m
+	}
+	keys, ok := m.hint.Keys(g)
+	if !ok {
+		if m.hint = NewHint(m.ai, g); m.hint == nil {
+			return m
+		}
+		keys, ok = m.hint.Keys(g)
+	}
+	if ok {
+		m.hintKeys = keys
+	}
+	return m
+}
+
+// shiftKey moves the piece for a left/right key
+// In modes with DAS the engine does the auto-repeat, so the terminal's
+// own key repeats only keep the key held until keyReleaseTimeout
+func (m model) shiftKey(key string, action Action) model {
+	if m.game.Mode.DAS == 0 {
+		m.game.Apply(action)
+		return m
+	}
+	if key == m.heldKey {
+		m.heldAt = time.Now()
+		return m
+	}
+	m = m.releaseHeldKey()
+	m.game.Press(action)
+	m.heldKey = key
+	m.heldAt = time.Now()
+	return m
+}
+
+// releaseHeldKey releases the held shift key, if any
+func (m model) releaseHeldKey() model {
+	switch m.heldKey {
+	case "a":
+		m.game.Release(ActionLeft)
+	case "d":
+		m.game.Release(ActionRight)
+	}
+	m.heldKey = ""
+	return m
+}
+
+// updateResults handles keys on the results screen
+func (m model) updateResults(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "v":
+		if m.lastReplay != nil {
+			return m.watchReplay(m.lastReplay)
+		}
+	case "r", "n":
+		if m.watch != nil && msg.String() == "r" {
+			return m.startGame(m.game.Mode)
+		}
+		if m.game.Mode.Opener != nil {
+			if msg.String() == "r" {
+				return m.playOpener(m.game.Mode, m.openerQueue)
+			}
+			return m.startGame(m.game.Mode)
+		}
+		i := slices.IndexFunc(m.puzzles, func(p Puzzle) bool { return p.ID == m.game.Mode.Puzzle })
+		if m.game.Mode.ID != ModePuzzle || i < 0 {
+			break
+		}
+		if msg.String() == "n" {
+			i = (i + 1) % len(m.puzzles)
+		}
+		return m.playPuzzle(i)
+	case "enter", " ", "esc":
+		m.screen = screenMenu
+		m.game = nil
+	}
+	return m, nil
+}
+
+// watchReplay opens the replay viewer, paused at the start of the
+// replay. Esc returns to the current screen
+func (m model) watchReplay(r *Replay) (tea.Model, tea.Cmd) {
+	player, err := NewReplayPlayer(r)
+	if err != nil {
+		m.replayErr = err
+		return m, nil
+	}
+	m.player = player
+	m.replaySpeed = slices.Index(replaySpeeds, 1)
+	m.replayBack = m.screen
+	m.screen = screenReplay
+	m.paused = true
+	m.lastTick = time.Now()
+	m.frameDebt = 0
+	return m, tick()
+}
+
+// updateReplay handles the replay viewer's controls
+// Stepping and seeking pause playback so the frame stays on screen
+func (m model) updateReplay(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := m.player
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "esc":
+		m.screen = m.replayBack
+		m.player = nil
+	case " ", "p":
+		if p.Done() {
+			p.Seek(0)
+		}
+		m.paused = !m.paused
+		m.lastTick = time.Now()
+		m.frameDebt = 0
+	case "right":
+		m.paused = true
+		p.Seek(p.Game.Frame + 1)
+	case "left":
+		m.paused = true
+		p.Seek(p.Game.Frame - 1)
+	case "]":
+		p.Seek(p.Game.Frame + replaySeekStep)
+	case "[":
+		p.Seek(p.Game.Frame - replaySeekStep)
+	case "up":
+		m.replaySpeed = min(m.replaySpeed+1, len(replaySpeeds)-1)
+	case "down":
+		m.replaySpeed = max(m.replaySpeed-1, 0)
+	}
+	return m, nil
+}
+
+// editorControls is the controls help of the board editor
+const editorControls = "←↑↓→/HJKL=Move | Space=Paint | X=Erase | Shift+X=Clear | 1-8/Tab=Brush | T=Stamp | R=Rotate\n" +
+	"N=Queue Brush | Bksp=Unqueue | C=Hold | S=Save | F=Fumen | Paste=Load Fumen | Enter=Play from Here | Esc=Menu"
+
+// editorHelp returns the editor's controls help, followed by the fumen
+// of the position when it has been exported
+func (m model) editorHelp() string {
+	if m.editorFumen == "" {
+		return editorControls
+	}
+	return editorControls + "\nFumen: " + m.editorFumen
+}
+
+// openEditor opens the board editor on the saved position, or an
+// empty board if there is none
+func (m model) openEditor() model {
+	pos, err := m.loadPosition()
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	m.positionErr = err
+	m.positionSaved = false
+	m.editor = NewEditor(pos)
+	m.screen = screenEditor
+	return m
+}
+
+// loadPosition reads the saved position
+func (m model) loadPosition() (Position, error) {
+	if m.positionPath == "" {
+		return Position{}, errors.New("positions can't be saved")
+	}
+	return LoadPosition(m.positionPath)
+}
+
+// playPosition starts a Practice game from a position, with the
+// options chosen for Practice on the menu
+func (m model) playPosition(pos Position) (tea.Model, tea.Cmd) {
+	mode := NewPracticeMode(m.practiceGravity)
+	for _, menuMode := range m.menuModes() {
+		if menuMode.ID == ModePractice {
+			mode = menuMode
+		}
+	}
+	m.game = NewGameAt(mode, uint64(time.Now().UnixNano()), pos)
+	return m.enterGame()
+}
+
+// updateEditor handles the board editor's keys
+func (m model) updateEditor(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	e := m.editor
+	m.positionSaved = false
+	m.editorFumen = ""
+	if msg.Paste {
+		// A pasted fumen replaces the position with its first page
+		pages, err := DecodeFumen(string(msg.Runes))
+		if err == nil {
+			var pos Position
+			if pos, err = FumenPosition(pages[0]); err == nil {
+				m.editor = NewEditor(pos)
 			}
-		case "down", "j":
-			// Move piece down
-			if m.currentPiece != nil {
-				m.currentPiece.Row++
+		}
+		m.positionErr = err
+		return m, nil
+	}
+	switch key := msg.String(); key {
+	case "q":
+		return m, tea.Quit
+	case "esc":
+		m.screen = screenMenu
+		m.editor = nil
+	case "up", "k":
+		e.Move(-1, 0)
+	case "down", "j":
+		e.Move(1, 0)
+	case "left", "h":
+		e.Move(0, -1)
+	case "right", "l":
+		e.Move(0, 1)
+	case " ":
+		e.Paint()
+	case "x":
+		e.Erase()
+	case "X":
+		e.Clear()
+	case "1", "2", "3", "4", "5", "6", "7", "8":
+		e.SetBrush(int(key[0] - '1'))
+	case "tab":
+		e.SetBrush(e.Brush + 1)
+	case "shift+tab":
+		e.SetBrush(e.Brush - 1)
+	case "t":
+		e.ToggleStamp()
+	case "r":
+		e.Rotate(1)
+	case "n":
+		e.PushQueue()
+	case "backspace":
+		e.PopQueue()
+	case "c":
+		e.ToggleHold()
+	case "s":
+		if m.positionPath == "" {
+			m.positionErr = errors.New("positions can't be saved")
+			break
+		}
+		m.positionErr = SavePosition(m.positionPath, e.Snapshot())
+		m.positionSaved = m.positionErr == nil
+	case "f":
+		m.editorFumen, m.positionErr = PositionFumen(e.Snapshot())
+	case "enter":
+		return m.playPosition(e.Snapshot())
+	}
+	return m, nil
+}
+
+// updateEditorMouse paints with the left button and erases with the
+// right, dragging to paint or erase several cells. In stamp mode a
+// left click stamps the piece at the clicked cell
+func (m model) updateEditorMouse(msg tea.MouseMsg) model {
+	if msg.Action == tea.MouseActionRelease {
+		return m
+	}
+	row, col, ok := m.boardCellAt(msg.X, msg.Y, m.editorHelp())
+	if !ok {
+		return m
+	}
+	e := m.editor
+	switch msg.Button {
+	case tea.MouseButtonLeft:
+		if e.Stamp != nil && msg.Action != tea.MouseActionPress {
+			return m
+		}
+		e.MoveTo(row, col)
+		e.Paint()
+	case tea.MouseButtonRight:
+		e.MoveTo(row, col)
+		e.Erase()
+	}
+	m.positionSaved = false
+	return m
+}
+
+// updateReplayTick plays the replay for the wall time since the last
+// tick, scaled by the playback speed
+func (m model) updateReplayTick(now time.Time) (tea.Model, tea.Cmd) {
+	p := m.player
+	if m.paused || p.Done() {
+		m.lastTick = now
+		return m, tick()
+	}
+
+	speed := replaySpeeds[m.replaySpeed]
+	frameTime := time.Second / FramesPerSecond
+	m.frameDebt += time.Duration(float64(now.Sub(m.lastTick)) * speed)
+	m.lastTick = now
+	m.frameDebt = min(m.frameDebt, time.Duration(10*speed*float64(frameTime)))
+	for m.frameDebt >= frameTime && !p.Done() {
+		m.frameDebt -= frameTime
+		p.Step()
+	}
+	return m, tick()
+}
+
+// updateTick advances the game by however many frames of wall time
+// have passed since the last tick
+func (m model) updateTick(now time.Time) (tea.Model, tea.Cmd) {
+	if m.screen == screenReplay {
+		return m.updateReplayTick(now)
+	}
+	if m.screen != screenPlaying {
+		return m, nil
+	}
+	if m.paused {
+		m.lastTick = now
+		return m, tick()
+	}
+
+	if m.heldKey != "" && now.Sub(m.heldAt) > keyReleaseTimeout {
+		m = m.releaseHeldKey()
+	}
+
+	frameTime := time.Second / FramesPerSecond
+	m.frameDebt += now.Sub(m.lastTick)
+	m.lastTick = now
+	// Cap catch-up so a stalled terminal doesn't fast-forward the game
+	m.frameDebt = min(m.frameDebt, 10*frameTime)
+	for m.frameDebt >= frameTime {
+		m.frameDebt -= frameTime
+		if m.watch != nil {
+			m.playAI()
+		}
+		m.game.Step()
+	}
+
+	if m.game.Over() {
+		return m.finishGame(), nil
+	}
+	return m.updateHint().autosaveZen(), tick()
+}
+
+// playAI lets the AI give its input for the frame, at the speed chosen
+// for watching it
+func (m model) playAI() {
+	speed := aiSpeeds[m.aiSpeed]
+	switch {
+	case speed == 0:
+		m.watch.PlayPiece(m.game)
+	case m.game.Frame%(FramesPerSecond/speed) == 0:
+		m.watch.Play(m.game)
+	}
+}
+
+// startGame begins a new game in the given mode, or continues the
+// saved session for Zen
+func (m model) startGame(mode Mode) (tea.Model, tea.Cmd) {
+	if mode.ID == ModeZen && m.zenSave != nil {
+		m.game = m.zenSave
+		// Keep the menu's gravity choice for the continued session
+		m.game.Mode.NoGravity = mode.NoGravity
+	} else {
+		seed := uint64(time.Now().UnixNano())
+		var start Position
+		if mode.Opener != nil {
+			queue, err := mode.Opener.Queue(mode, seed)
+			if m.openerErr = err; err != nil {
+				m.screen = screenMenu
+				m.game = nil
+				return m, nil
 			}
+			return m.playOpener(mode, queue)
 		}
+		if mode.ID == ModePuzzle {
+			start = m.puzzles[m.puzzleIndex].Position
+		}
+		m.game = NewGameAt(mode, seed, start)
+		if !mode.NoTopOut {
+			// Only games that can end are recorded; Zen never finishes
+			m.game.StartRecording(seed, start)
+		}
+	}
+	return m.enterGame()
+}
 
-	case tea.WindowSizeMsg:
-		// Handle terminal resize
-		m.width = msg.Width
-		m.height = msg.Height
-		m.ready = true
+// continueGame resumes the saved game, paused so the player can get
+// ready. The save is removed; quitting again saves the game afresh
+func (m model) continueGame() (tea.Model, tea.Cmd) {
+	m.watch = nil
+	m.game = m.resume
+	m = m.discardSave()
+	next, cmd := m.enterGame()
+	next.paused = true
+	return next, cmd
+}
+
+// discardSave forgets the saved game and deletes its file
+func (m model) discardSave() model {
+	m.resume = nil
+	m.resumeErr = nil
+	m.menuIndex = max(m.menuIndex, 0)
+	if m.savePath != "" {
+		if err := os.Remove(m.savePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.resumeErr = err
+		}
+	}
+	return m
+}
+
+// enterGame switches to the game screen to play m.game
+func (m model) enterGame() (model, tea.Cmd) {
+	m.zenPieces = m.game.Pieces
+	m.screen = screenPlaying
+	m.paused = false
+	m.heldKey = ""
+	// A Zen session autosaved mid-shift resumes with the shift held,
+	// which is released like any other once the key isn't repeated
+	switch m.game.HeldShift() {
+	case -1:
+		m.heldKey = "a"
+	case 1:
+		m.heldKey = "d"
 	}
+	m.lastTick = time.Now()
+	m.frameDebt = 0
+	m.hint, m.hinted, m.hintPieces = nil, false, -1
+	return m.updateHint(), tick()
+}
+
+// saveProgress saves the game being played so it can be continued:
+// Zen sessions to their own file, any other game as the saved game
+// offered by Continue on the menu
+func (m model) saveProgress() model {
+	if m.screen != screenPlaying || m.game == nil || m.game.Over() || m.watch != nil {
+		// The AI's games aren't continued
+		return m
+	}
+	// The key won't still be down when the game resumes
+	m = m.releaseHeldKey()
+	if m.game.Mode.ID == ModeZen {
+		return m.saveZen()
+	}
+	m.resume = m.game
+	if m.savePath != "" {
+		m.resumeErr = SaveGame(m.savePath, m.game)
+	}
+	return m
+}
 
+// autosaveZen saves a Zen session whenever another piece has locked
+func (m model) autosaveZen() model {
+	if m.game.Mode.ID != ModeZen || m.game.Pieces == m.zenPieces {
+		return m
+	}
+	return m.saveZen()
+}
+
+// saveZen saves the Zen session in progress so it can be continued
+// from the menu or on the next launch
+func (m model) saveZen() model {
+	if m.game == nil || m.game.Mode.ID != ModeZen {
+		return m
+	}
+	m.zenSave = m.game
+	m.zenPieces = m.game.Pieces
+	if m.zenPath != "" {
+		m.zenErr = SaveGame(m.zenPath, m.game)
+	}
+	return m
+}
+
+// finishGame records the result in the high score table and shows
+// the results screen
+func (m model) finishGame() model {
+	g := m.game
+	m = m.saveReplay()
+	m.rank = -1
+	m.saveErr = nil
+	if g.Mode.ID == ModePuzzle {
+		m.screen = screenResults
+		return m.recordPuzzle()
+	}
+	if g.Mode.Opener != nil {
+		// Openers are built or not, with no score to rank
+		m.screen = screenResults
+		return m
+	}
+	if m.watch != nil || m.hinted {
+		// The AI's scores aren't the player's, even in part
+		m.screen = screenResults
+		return m
+	}
+	if g.Mode.Ranking == RankFastest && g.Result != ResultCleared {
+		// An unfinished race has no time to rank
+		m.screen = screenResults
+		return m
+	}
+
+	m.rank = m.scores.Add(g.Mode.Key(), HighScore{
+		Score:      g.Score,
+		Lines:      g.Lines,
+		Level:      g.Level,
+		StartLevel: g.Mode.StartLevel,
+		Frames:     g.Frame,
+		Cleared:    g.Result == ResultCleared,
+		Grade:      g.modeGrade(),
+		Date:       time.Now(),
+	}, g.Mode.Ranking)
+	if m.scoresPath != "" {
+		m.saveErr = m.scores.Save(m.scoresPath)
+	}
+	m.screen = screenResults
+	return m
+}
+
+// recordPuzzle adds a solved puzzle to the progress file
+func (m model) recordPuzzle() model {
+	g := m.game
+	if i := slices.IndexFunc(m.puzzles, func(p Puzzle) bool { return p.ID == g.Mode.Puzzle }); i >= 0 {
+		// A continued game may be another puzzle than the menu's
+		m.puzzleIndex = i
+	}
+	_, solved := m.progress[g.Mode.Puzzle]
+	m.puzzleFirst = !solved && g.Result == ResultCleared
+	if g.Result != ResultCleared {
+		return m
+	}
+	m.progress.Solve(g)
+	if m.progressPath != "" {
+		m.saveErr = m.progress.Save(m.progressPath)
+	}
+	return m
+}
+
+// puzzlesSolved counts the loaded puzzles that have been solved
+func (m model) puzzlesSolved() int {
+	solved := 0
+	for _, p := range m.puzzles {
+		if _, ok := m.progress[p.ID]; ok {
+			solved++
+		}
+	}
+	return solved
+}
+
+// playOpener starts an opener game dealt the given queue
+func (m model) playOpener(mode Mode, queue []PieceType) (tea.Model, tea.Cmd) {
+	seed := uint64(time.Now().UnixNano())
+	start := Position{Queue: queue}
+	m.openerQueue = queue
+	m.game = NewGameAt(mode, seed, start)
+	m.game.StartRecording(seed, start)
+	return m.enterGame()
+}
+
+// playPuzzle starts the puzzle at index i, with the options chosen
+// for puzzles on the menu
+func (m model) playPuzzle(i int) (tea.Model, tea.Cmd) {
+	m.puzzleIndex = i
+	for _, mode := range m.menuModes() {
+		if mode.ID == ModePuzzle {
+			return m.startGame(mode)
+		}
+	}
 	return m, nil
 }
 
+// saveReplay checks the finished game's replay plays back to the same
+// result and saves it, keeping it to be watched from the results screen
+// A replay that doesn't reproduce its game is reported and not saved
+func (m model) saveReplay() model {
+	m.lastReplay = m.game.Recording()
+	m.replayErr = nil
+	m.replayPath = ""
+	if m.lastReplay == nil {
+		return m
+	}
+	if err := m.lastReplay.Verify(); err != nil {
+		m.replayErr = err
+		m.lastReplay = nil
+		return m
+	}
+	path, err := replayPath(m.lastReplay)
+	if err == nil {
+		err = SaveReplay(path, m.lastReplay)
+	}
+	if err != nil {
+		m.replayErr = err
+		return m
+	}
+	m.replayPath = path
+	return m
+}
+
 // View renders the UI
 func (m model) View() string {
 	if !m.ready {
 		return "Initializing..."
 	}
 
-	// Decide scale based on terminal size (1 or 2 only)
-	scale := 1
+	switch m.screen {
+	case screenPlaying:
+		return m.viewPlaying()
+	case screenResults:
+		return m.viewResults()
+	case screenReplay:
+		return m.viewReplay()
+	case screenEditor:
+		return m.viewEditor()
+	default:
+		return m.viewMenu()
+	}
+}
+
+// boardScale decides the board scale from the terminal size (1 or 2 only)
+func (m model) boardScale() int {
 	if m.width >= 60 && m.height >= 48 {
-		scale = 2
+		return 2
 	}
+	return 1
+}
+
+// boardCellAt returns the board cell layout draws at a terminal
+// position, for mouse hit-testing. controls is the controls text the
+// screen is laid out with, since a wide one moves the panels
+func (m model) boardCellAt(x, y int, controls string) (row, col int, ok bool) {
+	scale := m.boardScale()
+	sideOuter := sidePanelWidth + statsStyle.GetHorizontalBorderSize()
+	boardOuter := BoardWidth*2*scale + 4 + boardStyle.GetHorizontalBorderSize()
+	content := max(2*sideOuter+boardOuter+4, lipgloss.Width(controlsStyle.Render(controls)))
+
+	// lipgloss.Place centers the content, rounding the right gap
+	gap := max(m.width-content, 0)
+	left := gap - int(math.Round(float64(gap)*0.5))
+
+	// The board follows the stats panel, the gap, the board panel's
+	// border and padding, and its title and blank line
+	originX := left + sideOuter + 2 + boardStyle.GetBorderLeftSize() + boardStyle.GetPaddingLeft()
+	originY := boardStyle.GetBorderTopSize() + boardStyle.GetPaddingTop() + 2
+	if x < originX || y < originY {
+		return 0, 0, false
+	}
+	row = (y - originY) / scale
+	col = (x - originX) / (2 * scale)
+	return row, col, row < BoardHeight && col < BoardWidth
+}
+
+// layout arranges the three panels and the controls line
+func (m model) layout(statsContent, boardTitle, boardContent, nextTitle, nextContent, controlsText string) string {
+	scale := m.boardScale()
 
 	// Calculate exact board dimensions at chosen scale
 	// Base board: 10 cells wide (2 chars each) × 20 cells tall
-	boardRenderWidth := 10 * 2 * scale // 20 or 40 chars
-	boardRenderHeight := 20 * scale    // 20 or 40 lines
+	boardRenderWidth := BoardWidth * 2 * scale // 20 or 40 chars
+	boardRenderHeight := BoardHeight * scale   // 20 or 40 lines
 
 	// Panel dimensions: board + padding + title
 	boardPanelWidth := boardRenderWidth + 4   // +4 for padding (2 on each side)
 	boardPanelHeight := boardRenderHeight + 5 // +5 for title and padding
 
 	// Side panel dimensions
-	sideWidth := 20
+	sideWidth := sidePanelWidth
 	sideHeight := boardPanelHeight
 
 	// Stats panel
@@ -145,9 +1203,7 @@ func (m model) View() string {
 		AlignVertical(lipgloss.Top).
 		Render(
 			titleStyle.Render("Stats") + "\n\n" +
-				fmt.Sprintf("Score: %d\n", m.score) +
-				fmt.Sprintf("Level: %d\n", m.level) +
-				fmt.Sprintf("Lines: %d", m.lines),
+				statsContent,
 		)
 
 	// Board panel sized exactly for the board
@@ -156,8 +1212,8 @@ func (m model) View() string {
 		Height(boardPanelHeight).
 		AlignVertical(lipgloss.Top).
 		Render(
-			titleStyle.Render("Tetris") + "\n\n" +
-				renderBoardWithPiece(m.board, m.currentPiece, scale),
+			titleStyle.Render(boardTitle) + "\n\n" +
+				boardContent,
 		)
 
 	// Next pieces panel
@@ -166,9 +1222,8 @@ func (m model) View() string {
 		Height(sideHeight).
 		AlignVertical(lipgloss.Top).
 		Render(
-			titleStyle.Render("Next") + "\n\n" +
-				"Next pieces\n" +
-				"preview",
+			titleStyle.Render(nextTitle) + "\n\n" +
+				nextContent,
 		)
 
 	// Layout panels horizontally
@@ -181,18 +1236,7 @@ func (m model) View() string {
 		next,
 	)
 
-	// Controls at bottom (test mode)
-	pieceName := "None"
-	rotName := "0"
-	if m.currentPiece != nil {
-		pieceNames := []string{"I", "O", "T", "S", "Z", "J", "L"}
-		pieceName = pieceNames[m.currentPiece.Type]
-		rotNames := []string{"0", "R", "2", "L"}
-		rotName = rotNames[m.currentPiece.Rotation]
-	}
-	controls := controlsStyle.Render(
-		fmt.Sprintf("N/P=Next/Prev Piece | R=Rotate | Arrow/HJKL=Move | Q=Quit | Current: %s Rotation: %s", pieceName, rotName),
-	)
+	controls := controlsStyle.Render(controlsText)
 
 	// Join vertically (no extra spacing)
 	content := lipgloss.JoinVertical(
@@ -211,10 +1255,473 @@ func (m model) View() string {
 	)
 }
 
+// viewMenu renders the title menu with the selected mode's high scores
+func (m model) viewMenu() string {
+	modes := m.menuModes()
+	selected := modes[max(m.menuIndex, 0)]
+
+	menu := "Select a mode:\n\n"
+	if m.resume != nil {
+		label := "Continue " + m.resume.Mode.Name
+		if m.menuIndex < 0 {
+			menu += highlightStyle.Render("▶ "+label) + "\n\n"
+		} else {
+			menu += "  " + label + "\n\n"
+		}
+	}
+	for i, mode := range modes {
+		label := mode.Name
+		switch mode.ID {
+		case ModeMarathon, ModeEndless, ModeClassic, ModePentomino, ModeTriomino, ModeCustom:
+			label += fmt.Sprintf("  ◂ Lv %d ▸", mode.StartLevel)
+		case ModeUltra:
+			label += fmt.Sprintf("  ◂ %s ▸", formatFrames(mode.TimeLimit))
+		case ModeDig:
+			label += fmt.Sprintf("  ◂ %d lines ▸", mode.GarbageGoal)
+		case ModeZen, ModePractice:
+			gravity := "on"
+			if mode.NoGravity {
+				gravity = "off"
+			}
+			label += fmt.Sprintf("  ◂ Gravity %s ▸", gravity)
+		case ModePuzzle:
+			label = fmt.Sprintf("Puzzles  ◂ %d/%d ▸", m.puzzleIndex+1, len(m.puzzles))
+		case ModeOpener:
+			label = fmt.Sprintf("Openers  ◂ %d/%d ▸", m.openerIndex+1, len(m.openers))
+		}
+		if i == m.menuIndex {
+			menu += highlightStyle.Render("▶ "+label) + "\n"
+		} else {
+			menu += "  " + label + "\n"
+		}
+	}
+
+	if m.zenErr != nil {
+		menu += "\n" + fmt.Sprintf("Zen session: %v", m.zenErr)
+	}
+	if m.resumeErr != nil {
+		menu += "\n" + fmt.Sprintf("Saved game: %v", m.resumeErr)
+	}
+	if m.positionErr != nil {
+		menu += "\n" + fmt.Sprintf("Saved position: %v", m.positionErr)
+	}
+	if m.puzzleErr != nil {
+		menu += "\n" + fmt.Sprintf("Puzzles: %v", m.puzzleErr)
+	}
+	if m.openerErr != nil {
+		menu += "\n" + fmt.Sprintf("Openers: %v", m.openerErr)
+	}
+
+	if m.menuIndex < 0 {
+		g := m.resume
+		side := fmt.Sprintf("Score: %d\n", g.Score) +
+			fmt.Sprintf("Lines: %d\n", g.Lines) +
+			fmt.Sprintf("Level: %d\n", g.Level) +
+			fmt.Sprintf("Time:  %s\n\n", formatFrames(g.Frame)) +
+			dimStyle.Render("Enter to continue")
+		return m.layout(
+			"Pick up your "+g.Mode.Name+" game where you left off\n\n"+renderVariants(g.Mode),
+			"GoTetris",
+			menu,
+			"Saved Game",
+			side,
+			"↑/↓=Select | Enter=Continue | X=Discard | Q=Quit",
+		)
+	}
+
+	// Zen never ends, so it shows the saved session instead of scores
+	description := selected.Description
+	rules := selected
+	sideTitle := "High Scores"
+	side := renderHighScores(m.scores[selected.Key()], -1, selected.Ranking)
+	controls := "↑/↓=Select | ←/→=Adjust | R/B/I=Rotation/Big/Invisible | "
+	if m.watchable(selected) {
+		controls += fmt.Sprintf("V=Watch %s | ", m.watchedName())
+	}
+	controls += "Enter=Start | Q=Quit"
+	if selected.ID == ModeZen {
+		sideTitle = "Session"
+		side = dimStyle.Render("No saved session")
+		if m.zenSave != nil {
+			side = fmt.Sprintf("Score: %d\n", m.zenSave.Score) +
+				fmt.Sprintf("Lines: %d\n", m.zenSave.Lines) +
+				fmt.Sprintf("Time:  %s\n\n", formatFrames(m.zenSave.Frame)) +
+				dimStyle.Render("Enter to continue")
+			controls = "↑/↓=Select | ←/→=Gravity | Enter=Continue | N=New Session | Q=Quit"
+			rules = m.zenSave.Mode
+		}
+	}
+	if selected.Undo {
+		// Practice games never end, so there are no scores to show
+		sideTitle = "Practice"
+		side = dimStyle.Render("Z undoes a placement,\nY redoes it. Placing\na piece after an undo\nstarts a new branch")
+		controls = "↑/↓=Select | ←/→=Gravity | E=Editor | O=Open Saved Position | Enter=Start | Q=Quit"
+	}
+	if selected.Trainer {
+		// The trainer never ends either
+		sideTitle = "Finesse"
+		side = dimStyle.Render("A key is a tap, a\nshift to the wall\n(Shift+A/D) or a\nturn. Miss the\ntarget or press one\ntoo many and the\npiece comes back")
+		controls = "↑/↓=Select | R/B/I=Rotation/Big/Invisible | Enter=Start | Q=Quit"
+	}
+	if selected.ID == ModePuzzle {
+		// Puzzles show what has been solved instead of scores
+		sideTitle = fmt.Sprintf("Solved %d/%d", m.puzzlesSolved(), len(m.puzzles))
+		side = renderPuzzles(m.puzzles, m.progress, m.puzzleIndex)
+		description = highlightStyle.Render(selected.Name) + "\n" + selected.Description
+		if record, ok := m.progress[selected.Puzzle]; ok {
+			pieces := "pieces"
+			if record.Pieces == 1 {
+				pieces = "piece"
+			}
+			description += "\n" + dimStyle.Render(fmt.Sprintf("Best: %d %s, %s", record.Pieces, pieces, formatFrames(record.Frames)))
+		}
+		controls = "↑/↓=Select | ←/→=Puzzle | R/I=Rotation/Invisible | Enter=Start | Q=Quit"
+	}
+	if o := selected.Opener; o != nil {
+		// Openers show the stack they build instead of scores
+		sideTitle = "Opener"
+		side = renderMiniBoard(o.Steps[len(o.Steps)-1].Board) + "\n\n" +
+			dimStyle.Render(fmt.Sprintf("%d steps", len(o.Steps)))
+		description = highlightStyle.Render(selected.Name) + "\n" + selected.Description
+		controls = "↑/↓=Select | ←/→=Opener | R/I=Rotation/Invisible | Enter=Start | Q=Quit"
+	}
+
+	return m.layout(
+		description+"\n\n"+renderVariants(rules),
+		"GoTetris",
+		menu,
+		sideTitle,
+		side,
+		controls,
+	)
+}
+
+// viewPlaying renders the game in progress
+func (m model) viewPlaying() string {
+	g := m.game
+	title := g.Mode.Name
+	if g.Mode.Big {
+		title += " Big"
+	}
+	if g.Mode.Invisible {
+		title += " Invisible"
+	}
+	target, controls := g.Target, playControls(g.Mode)
+	if m.watch != nil {
+		title += fmt.Sprintf(" (%s)", m.watchedName())
+		target = m.watch.Target(g)
+		speed := "Instant"
+		if aiSpeeds[m.aiSpeed] > 0 {
+			speed = fmt.Sprintf("%d/s", aiSpeeds[m.aiSpeed])
+		}
+		controls = fmt.Sprintf("↑/↓=Speed (%s) | P=Pause | Esc=Menu | Q=Quit", speed)
+	}
+	if m.hint != nil {
+		target = &m.hint.Move.Placement.Piece
+		if m.hintKeys != nil {
+			controls = "Hint: " + describePath(m.hintKeys) + "\n" + controls
+		}
+	}
+	if m.paused {
+		title += " (Paused)"
+	}
+
+	// Invisible modes only show the piece in play
+	board := g.Board
+	if g.StackHidden() {
+		board = NewBoard()
+	}
+	return m.layout(
+		renderStats(g),
+		title,
+		renderBoardWithTarget(board, g.Current, target, m.boardScale()),
+		"Next",
+		renderQueue(g.Queue, g.Mode.Rotation),
+		controls,
+	)
+}
+
+// playControls returns the controls help for a mode, leaving out the
+// actions its ruleset disables
+func playControls(mode Mode) string {
+	controls := "A/D=Move | Shift+A/D=To Wall | S=Soft Drop | "
+	if !mode.NoHardDrop {
+		controls += "W/Space=Hard Drop | "
+	}
+	controls += "←/→=Rotate | "
+	if mode.rotates180() {
+		controls += "↑=180° | "
+	}
+	if !mode.NoHold {
+		controls += "C=Hold | "
+	}
+	if mode.Undo {
+		controls += "Z/Y=Undo/Redo | B=Branch | "
+	}
+	if mode.hintable() {
+		controls += "H=Hints | "
+	}
+	return controls + "P=Pause | Esc=Menu | Q=Quit"
+}
+
+// viewResults renders the final score and the mode's high scores
+func (m model) viewResults() string {
+	g := m.game
+	if g.Mode.ID == ModePuzzle {
+		return m.viewPuzzleResults()
+	}
+	if g.Mode.Opener != nil {
+		return m.viewOpenerResults()
+	}
+
+	heading := "Game Over"
+	switch g.Result {
+	case ResultTimeUp:
+		heading = "Time Up!"
+	case ResultCleared:
+		heading = g.Mode.Name + " Complete!"
+	}
+	results := highlightStyle.Render(heading) + "\n\n" +
+		fmt.Sprintf("Final Score: %d\n", g.Score) +
+		fmt.Sprintf("Lines: %d\n", g.Lines) +
+		fmt.Sprintf("Level: %d\n", g.Level) +
+		fmt.Sprintf("Time: %s\n", formatFrames(g.Frame))
+	if grade := g.modeGrade(); grade != "" {
+		results += fmt.Sprintf("Grade: %s\n", grade)
+	}
+	if g.Finesse.Judged > 0 {
+		results += fmt.Sprintf("Finesse: %d%%\n", g.Finesse.Percent())
+	}
+	results += "\n"
+	if m.rank >= 0 {
+		results += fmt.Sprintf("New high score: #%d\n", m.rank+1)
+	}
+	if m.saveErr != nil {
+		results += fmt.Sprintf("Could not save scores: %v\n", m.saveErr)
+	}
+	if m.replayErr != nil {
+		results += fmt.Sprintf("Could not save replay: %v\n", m.replayErr)
+	}
+
+	controls := "Enter=Menu | Q=Quit"
+	if m.lastReplay != nil {
+		controls = "V=Watch Replay | " + controls
+	}
+	if m.watch != nil {
+		player := "the AI"
+		if m.bot != nil {
+			player = m.bot.Info.Name
+			if err := m.bot.Err(); err != nil {
+				results += fmt.Sprintf("Bot stopped playing: %v\n", err)
+			}
+		}
+		results += fmt.Sprintf("Played by %s\n", player)
+		controls = "R=Watch Again | " + controls
+	} else if m.hinted {
+		results += "Played with hints\n"
+	}
+	return m.layout(
+		renderStats(g),
+		g.Mode.Name,
+		results,
+		"High Scores",
+		renderHighScores(m.scores[g.Mode.Key()], m.rank, g.Mode.Ranking),
+		controls,
+	)
+}
+
+// viewPuzzleResults renders whether a puzzle was solved and the
+// puzzles solved so far
+func (m model) viewPuzzleResults() string {
+	g := m.game
+
+	heading := "Puzzle Failed"
+	switch g.Result {
+	case ResultCleared:
+		heading = "Puzzle Solved!"
+	case ResultTopOut:
+		heading = "Game Over"
+	}
+	results := highlightStyle.Render(heading) + "\n\n" +
+		g.Mode.Description + "\n\n" +
+		fmt.Sprintf("Pieces: %d\n", g.Pieces) +
+		fmt.Sprintf("Lines: %d\n", g.Lines) +
+		fmt.Sprintf("Time: %s\n\n", formatFrames(g.Frame))
+	if m.puzzleFirst {
+		results += "First solve!\n"
+	}
+	if m.saveErr != nil {
+		results += fmt.Sprintf("Could not save progress: %v\n", m.saveErr)
+	}
+	if m.replayErr != nil {
+		results += fmt.Sprintf("Could not save replay: %v\n", m.replayErr)
+	}
+
+	controls := "R=Retry | N=Next Puzzle | Enter=Menu | Q=Quit"
+	if m.lastReplay != nil {
+		controls = "V=Watch Replay | " + controls
+	}
+	return m.layout(
+		renderStats(g),
+		g.Mode.Name,
+		results,
+		fmt.Sprintf("Solved %d/%d", m.puzzlesSolved(), len(m.puzzles)),
+		renderPuzzles(m.puzzles, m.progress, m.puzzleIndex),
+		controls,
+	)
+}
+
+// viewOpenerResults renders the end of an opener game: the board as it
+// was left, with a piece placed off the opener marked and its target
+// outlined, next to the step it should have built
+func (m model) viewOpenerResults() string {
+	g := m.game
+
+	heading := "Off the Opener"
+	switch g.Result {
+	case ResultCleared:
+		heading = "Opener Built!"
+	case ResultTopOut:
+		heading = "Game Over"
+	}
+	results := highlightStyle.Render(heading) + "\n\n"
+	board := *g.Board
+	if d := g.Deviation; d != nil {
+		results += fmt.Sprintf("The %s went off\nthe opener\n\n", d.Type)
+		for _, cell := range d.Cells() {
+			board.Cells[cell.Row][cell.Col] = NewFilledCell(ColorMiss)
+		}
+	}
+	if hold, ok := g.MissedHold(); ok {
+		results += fmt.Sprintf("The %s should be\nin hold\n\n", hold)
+	}
+	results += fmt.Sprintf("Step:  %d/%d\n", g.OpenerStep, len(g.Mode.Opener.Steps)) +
+		fmt.Sprintf("Time:  %s\n", formatFrames(g.Frame))
+	if m.replayErr != nil {
+		results += fmt.Sprintf("\nCould not save replay: %v\n", m.replayErr)
+	}
+
+	step := g.Mode.Opener.Steps[max(g.OpenerStep-1, 0)]
+	controls := "R=Retry | N=New Queue | Enter=Menu | Q=Quit"
+	if m.lastReplay != nil {
+		controls = "V=Watch Replay | " + controls
+	}
+	return m.layout(
+		results,
+		g.Mode.Name,
+		renderBoardWithTarget(&board, nil, g.Target, m.boardScale()),
+		fmt.Sprintf("Step %d", max(g.OpenerStep, 1)),
+		renderMiniBoard(step.Board),
+		controls,
+	)
+}
+
+// viewReplay renders the replay viewer: the game at the frame being
+// watched, the playback position and, at the end, whether the replay
+// reproduced the recorded game
+func (m model) viewReplay() string {
+	p := m.player
+	g := p.Game
+	title := fmt.Sprintf("Replay %s / %s  %s×", formatFrames(g.Frame), formatFrames(p.Replay.Frames),
+		strconv.FormatFloat(replaySpeeds[m.replaySpeed], 'f', -1, 64))
+
+	status := "Playing"
+	switch {
+	case p.Done() && p.Check() == nil:
+		status = "Verified"
+	case p.Done():
+		status = "Desynced"
+	case m.paused:
+		status = "Paused"
+	}
+	stats := renderStats(g) + "\n" +
+		highlightStyle.Render(status) + "\n" +
+		dimStyle.Render(p.Replay.Mode.Name+", "+p.Replay.Date.Format("2006-01-02 15:04"))
+
+	board := g.Board
+	if g.StackHidden() {
+		board = NewBoard()
+	}
+	return m.layout(
+		stats,
+		title,
+		renderBoardWithTarget(board, g.Current, g.Target, m.boardScale()),
+		"Next",
+		renderQueue(g.Queue, g.Mode.Rotation),
+		"Space=Play/Pause | ←/→=Step | [/]=Seek 10s | ↑/↓=Speed | Esc=Back | Q=Quit",
+	)
+}
+
+// viewEditor renders the board editor: the board with the cursor or
+// the piece being stamped, the brush and hold, and the queue
+func (m model) viewEditor() string {
+	e := m.editor
+	var board string
+	if e.Stamp != nil {
+		board = renderBoardWithPiece(e.Board, e.Stamp, m.boardScale())
+	} else {
+		cursor := *e.Board
+		cursor.Cells[e.Row][e.Col] = NewFilledCell(ColorCursor)
+		board = cursor.Render(m.boardScale())
+	}
+
+	tool := "Paint"
+	if e.Stamp != nil {
+		tool = "Stamp"
+	}
+	stats := fmt.Sprintf("Brush: %s\n", lipgloss.NewStyle().Foreground(lipgloss.Color(e.BrushColor())).Render(e.BrushName())) +
+		fmt.Sprintf("Tool:  %s\n", tool) +
+		fmt.Sprintf("Cell:  %d,%d\n", e.Row, e.Col)
+	switch {
+	case m.positionErr != nil:
+		stats += "\n" + fmt.Sprintf("Position: %v", m.positionErr) + "\n"
+	case m.positionSaved:
+		stats += "\n" + highlightStyle.Render("Position saved") + "\n"
+	}
+	stats += "\n" + titleStyle.Render("Hold") + "\n\n"
+	if e.HasHold {
+		stats += renderPiecePreview(e.Hold, "")
+	}
+
+	// The queue can be longer than the panel: the rest is listed by name
+	shown := e.Queue[:min(len(e.Queue), PreviewCount)]
+	queue := dimStyle.Render("Empty: pieces are random")
+	if len(shown) > 0 {
+		queue = renderQueue(shown, "")
+	}
+	if rest := e.Queue[len(shown):]; len(rest) > 0 {
+		names := make([]string, len(rest))
+		for i, t := range rest {
+			names[i] = t.String()
+		}
+		queue += "\n\n" + dimStyle.Render("then "+strings.Join(names, ""))
+	}
+
+	return m.layout(
+		stats,
+		"Editor",
+		board,
+		fmt.Sprintf("Queue (%d)", len(e.Queue)),
+		queue,
+		m.editorHelp(),
+	)
+}
+
 // renderBoardWithPiece renders the board with the current piece overlaid
 func renderBoardWithPiece(board *Board, piece *Piece, scale int) string {
+	return renderBoardWithTarget(board, piece, nil, scale)
+}
+
+// renderBoardWithTarget renders the board with the current piece
+// overlaid and a placement outlined where the piece isn't covering it
+func renderBoardWithTarget(board *Board, piece, target *Piece, scale int) string {
+	var outline []Offset
+	var outlineColor CellColor
+	if target != nil {
+		outline, outlineColor = target.Cells(), target.Color()
+	}
 	if piece == nil {
-		return board.Render(scale)
+		return board.RenderOutline(scale, outline, outlineColor)
 	}
 
 	// Get the cells occupied by the current piece
@@ -237,30 +1744,197 @@ func renderBoardWithPiece(board *Board, piece *Piece, scale int) string {
 		}
 	}
 
-	return tempBoard.Render(scale)
+	return tempBoard.RenderOutline(scale, outline, outlineColor)
 }
 
 func main() {
-	board := NewBoard()
+	// Subcommands such as fumen run without the game
+	if code, ok := runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); ok {
+		os.Exit(code)
+	}
+
+	ultraTime := flag.Duration("ultra-time", DefaultUltraTime, "length of an Ultra game")
+	messiness := flag.Float64("messiness", DefaultMessiness, "chance (0-1) a Dig garbage hole changes column")
+	piecesFile := flag.String("pieces", "", "JSON file of custom pieces to play as the Custom mode")
+	replayFile := flag.String("replay", "", "replay file to watch")
+	aiWeights := flag.String("ai-weights", "", "JSON file of AI weights (default: ai.json in the gotetris data directory, if any)")
+	botCommand := flag.String("bot", "", "command running an outside bot, which speaks the Tetris Bot Protocol, to watch instead of the AI")
+	flag.Parse()
+
+	var customPieces string
+	if *piecesFile != "" {
+		name, err := LoadPieceSet(*piecesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not load pieces: %v\n", err)
+			os.Exit(1)
+		}
+		customPieces = name
+	}
+
+	// A replay to watch is loaded after the pieces it may need
+	var replay *Replay
+	if *replayFile != "" {
+		var err error
+		if replay, err = LoadReplay(*replayFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not load replay: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// High scores are optional: if the file can't be located or read
+	// the game still runs, it just starts with empty tables
+	scoresPath, err := dataPath("highscores.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: high scores disabled: %v\n", err)
+	}
+	scores := HighScores{}
+	if scoresPath != "" {
+		if scores, err = LoadHighScores(scoresPath); err != nil {
+			// Don't overwrite a file we couldn't parse
+			fmt.Fprintf(os.Stderr, "Warning: could not read high scores: %v\n", err)
+			scoresPath = ""
+		}
+	}
 
-	// Create a test piece (T piece near top-center for visibility)
-	testPiece := NewPiece(PieceT, 2, 3)
+	// A saved Zen session is continued from the menu
+	zenPath, err := dataPath("zen.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Zen sessions won't be saved: %v\n", err)
+	}
+	// A game saved on quit is offered by Continue on the menu
+	savePath, err := dataPath("save.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: games won't be saved on quit: %v\n", err)
+	}
+	// Positions built in the board editor are saved for Practice
+	positionPath, err := dataPath("position.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: editor positions won't be saved: %v\n", err)
+	}
+	// Puzzles: the starter pack, then the player's own from the puzzles
+	// data directory. The ones solved are kept in the progress file
+	puzzles, puzzleErr := StarterPuzzles()
+	if puzzleDir, err := dataPath("puzzles"); err == nil {
+		userPuzzles, err := LoadPuzzles(puzzleDir)
+		puzzles = append(puzzles, userPuzzles...)
+		puzzleErr = cmp.Or(puzzleErr, err)
+	}
+	progressPath, err := dataPath("puzzles.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: puzzle progress won't be saved: %v\n", err)
+	}
+	progress := PuzzleProgress{}
+	if progressPath != "" {
+		if progress, err = LoadPuzzleProgress(progressPath); err != nil {
+			// Don't overwrite a file we couldn't parse
+			fmt.Fprintf(os.Stderr, "Warning: could not read puzzle progress: %v\n", err)
+			progressPath = ""
+		}
+	}
+	// Openers: the starter pack, then the player's own from the openers
+	// data directory
+	openers, openerErr := StarterOpeners()
+	if openerDir, err := dataPath("openers"); err == nil {
+		userOpeners, err := LoadOpeners(openerDir)
+		openers = append(openers, userOpeners...)
+		openerErr = cmp.Or(openerErr, err)
+	}
+
+	// The AI plays with the default weights unless tuned ones are found
+	weights, err := loadAIWeights(*aiWeights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read AI weights, using the defaults: %v\n", err)
+		weights = DefaultAIWeights()
+	}
+	var bot *TBPBot
+	if *botCommand != "" {
+		// The bot's stderr would write over the game
+		if bot, err = StartTBPBot(strings.Fields(*botCommand), io.Discard); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not start the bot: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// os.Exit skips deferred calls, so the bot is closed by hand before
+	// every exit from here on
+	closeBot := func() {
+		if bot != nil {
+			bot.Close()
+		}
+	}
+
+	var resume *Game
+	var resumeErr error
+	if savePath != "" {
+		resume, resumeErr = LoadGame(savePath)
+		if errors.Is(resumeErr, os.ErrNotExist) {
+			resumeErr = nil
+		}
+	}
+	menuIndex := 0
+	if resume != nil {
+		menuIndex = -1
+	}
+
+	var zenSave *Game
+	var zenErr error
+	if zenPath != "" {
+		zenSave, zenErr = LoadGame(zenPath)
+		if errors.Is(zenErr, os.ErrNotExist) {
+			zenErr = nil
+		}
+	}
+
+	m := model{
+		startLevel:   1,
+		ultraTime:    *ultraTime,
+		digGoal:      DefaultDigGoal,
+		messiness:    min(max(*messiness, 0), 1),
+		customPieces: customPieces,
+		zenGravity:   true,
+		options:      map[ModeID]modeOptions{},
+		zenPath:      zenPath,
+		positionPath: positionPath,
+		puzzles:      puzzles,
+		puzzleErr:    puzzleErr,
+		progress:     progress,
+		progressPath: progressPath,
+		openers:      openers,
+		openerErr:    openerErr,
+		menuIndex:    menuIndex,
+		savePath:     savePath,
+		resume:       resume,
+		resumeErr:    resumeErr,
+		zenSave:      zenSave,
+		zenErr:       zenErr,
+		scores:       scores,
+		scoresPath:   scoresPath,
+		rank:         -1,
+		ai:           NewAI(weights),
+		bot:          bot,
+		aiSpeed:      slices.Index(aiSpeeds, 20),
+	}
+	if replay != nil {
+		// Watching a replay from the command line returns to the menu
+		next, _ := m.watchReplay(replay)
+		if m = next.(model); m.screen != screenReplay {
+			fmt.Fprintf(os.Stderr, "Error: could not load replay: %v\n", m.replayErr)
+			closeBot()
+			os.Exit(1)
+		}
+		m.replayBack = screenMenu
+	}
 
 	// Create the program with alt screen mode (fullscreen)
 	p := tea.NewProgram(
-		model{
-			score:        0,
-			level:        1,
-			lines:        0,
-			board:        board,
-			currentPiece: testPiece,
-		},
+		m,
 		tea.WithAltScreen(),       // Fullscreen mode
 		tea.WithMouseCellMotion(), // Mouse support
 	)
 
 	// Run the program
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	closeBot()
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
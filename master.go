@@ -0,0 +1,152 @@
+package main
+
+// Master mode follows TGM: the level counter goes up by one for every
+// piece that enters and by the number of lines cleared, stops at the
+// end of each 100-level section until a line is cleared, and the game
+// is complete at level 999. Gravity ramps up to 20G by level 500
+
+const (
+	MasterMaxLevel = 999 // Level that completes Master mode
+
+	// masterGravityScale converts TGM's 1/256 G units to gravityUnit
+	masterGravityScale = gravityUnit / 256
+)
+
+// masterGravityLevel is the internal gravity from a level onwards,
+// in TGM's units of 1/256 G
+type masterGravityLevel struct {
+	level   int
+	gravity int
+}
+
+// masterGravityTable is TGM's internal gravity curve, including the
+// drop back to slow speed at level 200
+var masterGravityTable = []masterGravityLevel{
+	{0, 4}, {30, 6}, {35, 8}, {40, 10}, {50, 12}, {60, 16}, {70, 32},
+	{80, 48}, {90, 64}, {100, 80}, {120, 96}, {140, 112}, {160, 128},
+	{170, 144}, {200, 4}, {220, 32}, {230, 64}, {233, 96}, {236, 128},
+	{239, 160}, {243, 192}, {247, 224}, {251, 256}, {300, 512},
+	{330, 768}, {360, 1024}, {400, 1280}, {420, 1024}, {450, 768},
+	{500, 5120},
+}
+
+// masterGrade is the score needed for a grade
+type masterGrade struct {
+	name  string
+	score int
+}
+
+// masterGrades are TGM's grades from lowest to highest
+var masterGrades = []masterGrade{
+	{"9", 0}, {"8", 400}, {"7", 800}, {"6", 1400}, {"5", 2000},
+	{"4", 3500}, {"3", 5500}, {"2", 8000}, {"1", 12000},
+	{"S1", 16000}, {"S2", 22000}, {"S3", 30000}, {"S4", 40000},
+	{"S5", 52000}, {"S6", 66000}, {"S7", 82000}, {"S8", 100000},
+	{"S9", 120000},
+}
+
+// masterCheckpoint is a requirement for the Grand Master grade:
+// reaching the level with at least the score within the time
+type masterCheckpoint struct {
+	level  int
+	score  int
+	frames int
+}
+
+// masterCheckpoints are TGM's Grand Master requirements
+var masterCheckpoints = []masterCheckpoint{
+	{300, 12000, (4*60 + 15) * FramesPerSecond},
+	{500, 40000, (7*60 + 30) * FramesPerSecond},
+	{999, 126000, (13*60 + 30) * FramesPerSecond},
+}
+
+// masterState is the extra bookkeeping Master scoring and grading need
+type masterState struct {
+	combo      int  // TGM combo multiplier, 1 when no combo is running
+	softRows   int  // Rows soft dropped by the current piece
+	gmEligible bool // Every Grand Master checkpoint so far was met
+}
+
+// newMasterState returns the state at the start of a game
+func newMasterState() masterState {
+	return masterState{combo: 1, gmEligible: true}
+}
+
+// masterGravity returns the fall speed for a Master level in
+// 1/gravityUnit cells per frame
+func masterGravity(level int) int {
+	gravity := masterGravityTable[0].gravity
+	for _, step := range masterGravityTable {
+		if level >= step.level {
+			gravity = step.gravity
+		}
+	}
+	return gravity * masterGravityScale
+}
+
+// scoreMaster scores a lock with TGM's formula, which rewards clearing
+// at higher levels, soft dropping, combos and clearing the whole board
+func (g *Game) scoreMaster(cleared int) {
+	if cleared == 0 {
+		g.master.combo = 1
+		return
+	}
+
+	g.master.combo += 2*cleared - 2
+	bravo := 1
+	after := *g.Board
+	after.ClearLines()
+	if after.Empty() {
+		bravo = 4
+	}
+	base := (g.Level+cleared+3)/4 + g.master.softRows
+	g.Score += base * cleared * g.master.combo * bravo
+	g.Lines += cleared
+	g.advanceMasterLevel(cleared, true)
+}
+
+// advanceMasterLevel adds to the level counter, checking the Grand
+// Master requirements and the end of the game
+// Pieces entering don't advance past the last level of a section
+// (x99, or 998); only clearing lines does
+func (g *Game) advanceMasterLevel(amount int, lineClear bool) {
+	if !lineClear && (g.Level%100 == 99 || g.Level == MasterMaxLevel-1) {
+		return
+	}
+
+	previous := g.Level
+	g.Level = min(g.Level+amount, MasterMaxLevel)
+	for _, checkpoint := range masterCheckpoints {
+		if previous < checkpoint.level && g.Level >= checkpoint.level &&
+			(g.Score < checkpoint.score || g.Frame > checkpoint.frames) {
+			g.master.gmEligible = false
+		}
+	}
+	if g.Level >= MasterMaxLevel {
+		g.Result = ResultCleared
+	}
+}
+
+// modeGrade returns the grade for modes that award one, or ""
+func (g *Game) modeGrade() string {
+	if g.Mode.Levels != LevelsMaster {
+		return ""
+	}
+	return g.Grade()
+}
+
+// Grade returns the player's current Master grade: 9 up to 1, then S1
+// to S9 by score, and GM for completing the game within every
+// Grand Master requirement
+func (g *Game) Grade() string {
+	if g.Level >= MasterMaxLevel && g.master.gmEligible {
+		return "GM"
+	}
+	grade := masterGrades[0].name
+	for _, step := range masterGrades {
+		if g.Score >= step.score {
+			grade = step.name
+		}
+	}
+	return grade
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// FramesPerSecond is the fixed engine tick rate; all game timers are
+// counted in frames so the engine is independent of wall-clock jitter
+const FramesPerSecond = 60
+
+// ModeID identifies a game mode
+type ModeID string
+
+const (
+	ModeMarathon  ModeID = "marathon"
+	ModeEndless   ModeID = "endless"
+	ModeUltra     ModeID = "ultra"
+	ModeDig       ModeID = "dig"
+	ModeSurvival  ModeID = "survival"
+	ModeZen       ModeID = "zen"
+	ModeMaster    ModeID = "master"
+	ModeClassic   ModeID = "classic"
+	ModePentomino ModeID = "pentomino"
+	ModeTriomino  ModeID = "triomino"
+	ModeCustom    ModeID = "custom"
+	ModePractice  ModeID = "practice"
+	ModePuzzle    ModeID = "puzzle"
+	ModeFinesse   ModeID = "finesse"
+	ModeOpener    ModeID = "opener"
+)
+
+// LevelSystem decides how levels advance, how fast pieces fall at each
+// level and how line clears score
+type LevelSystem int
+
+const (
+	LevelsGuideline LevelSystem = iota // Level up every 10 lines, guideline speed and scoring
+	LevelsMaster                       // TGM level counter to 999, gravity table and scoring
+	LevelsNES                          // NES level transitions, frame table and scoring
+)
+
+const (
+	DefaultUltraTime = 2 * time.Minute // Standard length of an Ultra game
+	MarathonLineGoal = 150             // Lines to complete Marathon (15 levels)
+	MaxStartLevel    = 15              // Highest level selectable from the menu
+	DefaultDigGoal   = 18              // Garbage lines to clear in Dig
+	DigHeight        = 10              // Garbage rows on the board at once in Dig
+)
+
+// DigGoals are the garbage line counts offered for Dig on the menu
+var DigGoals = []int{10, 18, 40, 100}
+
+// Mode describes the rules and goal of a game
+type Mode struct {
+	ID          ModeID
+	Name        string
+	Description string
+	TimeLimit   int // Game ends after this many frames (0 = no limit)
+	LineGoal    int // Game is won after clearing this many lines (0 = no goal)
+	StartLevel  int // Level the game begins at
+
+	GarbageGoal   int     // Game is won after clearing this many garbage lines (0 = no goal)
+	GarbageHeight int     // Garbage rows kept on the board until the goal is dealt
+	Messiness     float64 // Chance a garbage hole changes column, see GarbageGenerator
+	Ranking       Ranking // How the high score table is ordered
+
+	RiseInterval    int // Frames between rising garbage rows at the start (0 = no rising)
+	RiseMinInterval int // Fastest the rising garbage can get, in frames
+
+	NoTopOut  bool // Topping out clears the board instead of ending the game
+	NoGravity bool // Pieces only fall when dropped
+
+	Levels         LevelSystem
+	ARE            int  // Entry delay in frames before each piece spawns
+	LineClearDelay int  // Frames cleared rows stay on screen before collapsing
+	LockDelay      int  // Frames a grounded piece waits before locking (0 = DefaultLockDelay)
+	StepReset      bool // Lock delay only restarts when the piece falls, not on moves
+	DAS            int  // Frames a direction is held before auto-shift (0 = key repeat only)
+
+	Rotation   string // Rotation system name, see rotationSystems ("" = SRS)
+	Randomizer string // Randomizer name, see newRandomizer ("" = 7-bag)
+	Pieces     string // Piece set name, see pieceSets ("" = tetrominoes)
+	Previews   int    // Upcoming pieces shown (0 = PreviewCount)
+	NoHold     bool   // Hold is disabled
+	NoHardDrop bool   // Hard drop is disabled
+	NoInitial  bool   // Rotate and hold aren't saved for the next piece (no IRS/IHS)
+
+	Big       bool // Pieces are made of 2×2 blocks on a half-size grid
+	Invisible bool // Locked blocks vanish, flashing back on line clears
+
+	Undo bool // Placements can be undone and redone, see History
+
+	Puzzle     string // ID of the puzzle being played, see Puzzle
+	Goal       Goal   // Objective checked after every lock, see Game.checkGoal ("" = none)
+	GoalLines  int    // Lines to clear for GoalLines
+	PieceLimit int    // Game is lost once this many pieces lock short of the goal (0 = no limit)
+	NoRandom   bool   // Only the starting position's queue is dealt; the game is lost when it runs out
+
+	Trainer bool    // Pieces are placed on targets instead of locking, see Game.trainFinesse
+	Opener  *Opener // Opener to build, placing each piece on its target (nil = none)
+}
+
+// NewMarathonMode creates a game won by clearing MarathonLineGoal lines
+func NewMarathonMode(startLevel int) Mode {
+	return Mode{
+		ID:          ModeMarathon,
+		Name:        "Marathon",
+		Description: fmt.Sprintf("Clear %d lines as the speed rises every 10", MarathonLineGoal),
+		LineGoal:    MarathonLineGoal,
+		StartLevel:  startLevel,
+	}
+}
+
+// NewEndlessMode creates a Marathon without a line cap, played until
+// the stack tops out
+func NewEndlessMode(startLevel int) Mode {
+	return Mode{
+		ID:          ModeEndless,
+		Name:        "Endless",
+		Description: "Marathon with no line cap: survive as long as you can",
+		StartLevel:  startLevel,
+	}
+}
+
+// NewUltraMode creates a score attack that ends after the given time
+func NewUltraMode(limit time.Duration) Mode {
+	return Mode{
+		ID:          ModeUltra,
+		Name:        "Ultra",
+		Description: fmt.Sprintf("Score as much as possible in %s", formatFrames(durationToFrames(limit))),
+		TimeLimit:   durationToFrames(limit),
+		StartLevel:  1,
+	}
+}
+
+// NewDigMode creates a race to clear goal lines of garbage, with
+// up to DigHeight rows of it on the board at a time
+func NewDigMode(goal int, messiness float64) Mode {
+	return Mode{
+		ID:            ModeDig,
+		Name:          "Dig",
+		Description:   fmt.Sprintf("Clear %d lines of garbage as fast as you can", goal),
+		StartLevel:    1,
+		GarbageGoal:   goal,
+		GarbageHeight: DigHeight,
+		Messiness:     messiness,
+		Ranking:       RankFastest,
+	}
+}
+
+// NewSurvivalMode creates a game where garbage rises from the bottom
+// on a timer that speeds up with every row
+func NewSurvivalMode(messiness float64) Mode {
+	return Mode{
+		ID:              ModeSurvival,
+		Name:            "Survival",
+		Description:     "Garbage rises faster and faster: dig to stay alive",
+		StartLevel:      1,
+		Messiness:       messiness,
+		Ranking:         RankLongest,
+		RiseInterval:    8 * FramesPerSecond,
+		RiseMinInterval: 1 * FramesPerSecond,
+	}
+}
+
+// NewZenMode creates a relaxed game with no game over and, optionally,
+// no gravity
+func NewZenMode(gravity bool) Mode {
+	return Mode{
+		ID:          ModeZen,
+		Name:        "Zen",
+		Description: "No game over, no pressure: topping out just clears the board",
+		StartLevel:  1,
+		NoTopOut:    true,
+		NoGravity:   !gravity,
+	}
+}
+
+// NewPracticeMode creates a sandbox for practicing openers and
+// downstacking: placements can be undone and redone, and topping out
+// clears the board as in Zen
+func NewPracticeMode(gravity bool) Mode {
+	return Mode{
+		ID:          ModePractice,
+		Name:        "Practice",
+		Description: "Undo and redo placements to try openers and downstacking",
+		StartLevel:  1,
+		NoTopOut:    true,
+		NoGravity:   !gravity,
+		Undo:        true,
+	}
+}
+
+// NewPuzzleMode creates a game that plays a puzzle: its position is
+// all there is to play with, and it is won by reaching its goal within
+// its piece limit. Pieces only fall when dropped
+func NewPuzzleMode(p Puzzle) Mode {
+	return Mode{
+		ID:          ModePuzzle,
+		Name:        p.Name,
+		Description: p.Objective(),
+		StartLevel:  1,
+		NoGravity:   true,
+		Puzzle:      p.ID,
+		Goal:        p.Goal,
+		GoalLines:   p.GoalLines,
+		PieceLimit:  p.PieceLimit,
+		NoRandom:    true,
+	}
+}
+
+// NewFinesseMode creates the finesse trainer: each piece is to be put
+// on a target placement with the fewest keys, on an empty board that
+// never fills up
+func NewFinesseMode() Mode {
+	return Mode{
+		ID:          ModeFinesse,
+		Name:        "Finesse",
+		Description: "Place each piece on the outlined target with the fewest keys",
+		StartLevel:  1,
+		NoGravity:   true,
+		NoHold:      true,
+		NoInitial:   true,
+		Trainer:     true,
+	}
+}
+
+// NewOpenerMode creates a game that builds an opener step by step from
+// a queue it can be built from. Placing a piece off the opener loses
+func NewOpenerMode(o *Opener) Mode {
+	return Mode{
+		ID:          ModeOpener,
+		Name:        o.Name,
+		Description: "Build the opener by placing each piece on its outlined target",
+		StartLevel:  1,
+		NoGravity:   true,
+		Opener:      o,
+	}
+}
+
+// NewMasterMode creates a TGM-style game: gravity ramps up to 20G,
+// with entry and line clear delays and ARS rotation, graded on
+// performance
+func NewMasterMode() Mode {
+	return Mode{
+		ID:             ModeMaster,
+		Name:           "Master",
+		Description:    "Reach level 999 as gravity climbs to 20G and earn a grade",
+		Levels:         LevelsMaster,
+		ARE:            30,
+		LineClearDelay: 41,
+		LockDelay:      30,
+		StepReset:      true,
+		DAS:            16,
+		Rotation:       RotationARS,
+	}
+}
+
+// NewClassicMode creates a game with the NES ruleset: NES rotation
+// and randomizer, no hold or hard drop, and a single preview
+func NewClassicMode(startLevel int) Mode {
+	return Mode{
+		ID:             ModeClassic,
+		Name:           "Classic",
+		Description:    "NES rules: no hold, no hard drop, no kicks, one preview",
+		StartLevel:     startLevel,
+		Levels:         LevelsNES,
+		ARE:            10,
+		LineClearDelay: 18,
+		StepReset:      true,
+		Rotation:       RotationNRS,
+		Randomizer:     RandomizerNES,
+		Previews:       1,
+		NoHold:         true,
+		NoHardDrop:     true,
+		NoInitial:      true,
+	}
+}
+
+// allowsBig reports whether the mode can be played with big pieces
+// Garbage holes are one cell wide, which big pieces can't fill, and
+// puzzles and openers are set on the normal grid
+func (m Mode) allowsBig() bool {
+	return m.GarbageGoal == 0 && m.RiseInterval == 0 && m.Goal == GoalNone && m.Opener == nil
+}
+
+// watchable reports whether the AI can be watched playing the mode:
+// modes played for a score, not Zen, Practice or the trainers, which
+// never end, or puzzles, whose goals it doesn't aim for
+func (m Mode) watchable() bool {
+	return !m.NoTopOut && !m.Undo && !m.Trainer && m.Goal == GoalNone && m.Opener == nil
+}
+
+// hintable reports whether the AI can suggest placements in the mode:
+// not in the trainers or puzzles, where it would chase its own idea of
+// a good board instead of the target or goal
+func (m Mode) hintable() bool {
+	return !m.Trainer && m.Goal == GoalNone && m.Opener == nil
+}
+
+// spawnPiece returns a piece of the given type in its spawn state
+func (m Mode) spawnPiece(pieceType PieceType) *Piece {
+	if m.Big {
+		return NewBigSpawnPiece(pieceType, m.Rotation)
+	}
+	return NewSpawnPiece(pieceType, m.Rotation)
+}
+
+// rotates180 reports whether the mode's rotation system turns pieces
+// 180°, which only SRS+ does
+func (m Mode) rotates180() bool {
+	return supports180(m.Rotation)
+}
+
+// NewPieceSetMode creates a Marathon played with another piece set
+func NewPieceSetMode(id ModeID, name, pieces string, startLevel int) Mode {
+	mode := NewMarathonMode(startLevel)
+	mode.ID = id
+	mode.Name = name
+	mode.Description = fmt.Sprintf("Marathon with %d pieces: clear %d lines", len(pieceSet(pieces)), MarathonLineGoal)
+	mode.Pieces = pieces
+	return mode
+}
+
+// previewCount returns how many upcoming pieces the mode shows
+func (m Mode) previewCount() int {
+	if m.Previews > 0 {
+		return m.Previews
+	}
+	return PreviewCount
+}
+
+// Key returns the identifier used to file high scores for this mode
+// Timed modes of different lengths, dig races of different sizes,
+// custom piece sets and the big and invisible variants are ranked
+// separately
+func (m Mode) Key() string {
+	key := string(m.ID)
+	switch {
+	case m.TimeLimit > 0:
+		key = fmt.Sprintf("%s-%ds", m.ID, m.TimeLimit/FramesPerSecond)
+	case m.GarbageGoal > 0:
+		// Messier garbage is harder, so it gets its own table
+		key = fmt.Sprintf("%s-%d-m%d", m.ID, m.GarbageGoal, int(m.Messiness*100))
+	case m.RiseInterval > 0:
+		key = fmt.Sprintf("%s-m%d", m.ID, int(m.Messiness*100))
+	case m.ID == ModeCustom:
+		// Every piece set file gets its own table
+		key = fmt.Sprintf("%s-%s", m.ID, m.Name)
+	case m.ID == ModePuzzle:
+		key = fmt.Sprintf("%s-%s", m.ID, m.Puzzle)
+	case m.Opener != nil:
+		key = fmt.Sprintf("%s-%s", m.ID, m.Opener.ID)
+	}
+	if m.Big {
+		key += "-big"
+	}
+	if m.Invisible {
+		key += "-invisible"
+	}
+	return key
+}
+
+// durationToFrames converts a wall-clock duration to engine frames
+func durationToFrames(d time.Duration) int {
+	return int(d * FramesPerSecond / time.Second)
+}
+
+// formatFrames formats a frame count as m:ss.cc
+func formatFrames(frames int) string {
+	if frames < 0 {
+		frames = 0
+	}
+	centis := frames * 100 / FramesPerSecond
+	return fmt.Sprintf("%d:%02d.%02d", centis/6000, centis/100%60, centis%100)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Board notation is plain text for a board: a line per row with the
+// bottom row last, and a character per cell: the letter of the piece
+// whose color it has, # for garbage (or any other color) and _ for
+// empty. Rows above the first line are empty. A position adds optional
+// hold and queue headers before the board:
+//
+//	hold: T
+//	queue: IOSZ
+//	_______I__
+//	TTT_##_I__
+
+// notationEmpty and notationGarbage are the notation for an empty and
+// a garbage cell
+const (
+	notationEmpty   = '_'
+	notationGarbage = '#'
+)
+
+// colorPiece returns the tetromino a cell color belongs to
+func colorPiece(color CellColor) (PieceType, bool) {
+	for _, t := range Tetrominoes {
+		if (&Piece{Type: t}).Color() == color {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// cellLetter returns the notation for a cell
+func cellLetter(cell Cell) byte {
+	if !cell.Filled {
+		return notationEmpty
+	}
+	if t, ok := colorPiece(cell.Color); ok {
+		return t.String()[0]
+	}
+	return notationGarbage
+}
+
+// FormatBoard writes a board in board notation, from its highest
+// filled row down. An empty board is a single empty row
+func FormatBoard(b *Board) string {
+	top := BoardHeight - 1
+	for row := BoardHeight - 1; row >= 0; row-- {
+		for _, cell := range b.Cells[row] {
+			if cell.Filled {
+				top = row
+			}
+		}
+	}
+	var text strings.Builder
+	for row := top; row < BoardHeight; row++ {
+		for _, cell := range b.Cells[row] {
+			text.WriteByte(cellLetter(cell))
+		}
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+// ParseBoard reads a board written in board notation
+// Blank lines and surrounding spaces are ignored
+func ParseBoard(text string) (*Board, error) {
+	var rows []string
+	for line := range strings.Lines(text) {
+		if line = strings.TrimSpace(line); line != "" {
+			rows = append(rows, line)
+		}
+	}
+	if len(rows) > BoardHeight {
+		return nil, fmt.Errorf("board has %d rows, at most %d allowed", len(rows), BoardHeight)
+	}
+
+	b := NewBoard()
+	for i, line := range rows {
+		row := BoardHeight - len(rows) + i
+		if len(line) != BoardWidth {
+			return nil, fmt.Errorf("row %q is not %d cells wide", line, BoardWidth)
+		}
+		for col := range BoardWidth {
+			switch c := line[col]; c {
+			case notationEmpty:
+			case notationGarbage:
+				b.Cells[row][col] = NewFilledCell(ColorGarbage)
+			default:
+				pieces, err := parsePieceLetters(string(c))
+				if err != nil {
+					return nil, fmt.Errorf("row %q: %w", line, err)
+				}
+				b.Cells[row][col] = NewFilledCell((&Piece{Type: pieces[0]}).Color())
+			}
+		}
+	}
+	return b, nil
+}
+
+// FormatPosition writes a position in board notation, with hold and
+// queue headers when it has a held piece or a queue
+func FormatPosition(pos Position) string {
+	var text strings.Builder
+	if pos.HasHold {
+		fmt.Fprintf(&text, "hold: %s\n", pos.Hold)
+	}
+	if len(pos.Queue) > 0 {
+		text.WriteString("queue: ")
+		for _, t := range pos.Queue {
+			text.WriteString(t.String())
+		}
+		text.WriteByte('\n')
+	}
+	board := pos.Board
+	if board == nil {
+		board = NewBoard()
+	}
+	text.WriteString(FormatBoard(board))
+	return text.String()
+}
+
+// ParsePosition reads a position written with FormatPosition
+func ParsePosition(text string) (Position, error) {
+	var pos Position
+	var board strings.Builder
+	for line := range strings.Lines(text) {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			board.WriteString(line)
+			continue
+		}
+		if board.Len() > 0 && strings.TrimSpace(board.String()) != "" {
+			return Position{}, errors.New("headers must come before the board")
+		}
+		value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+		pieces, err := parsePieceLetters(value)
+		if err != nil {
+			return Position{}, fmt.Errorf("%s: %w", strings.TrimSpace(key), err)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "hold":
+			if len(pieces) > 1 {
+				return Position{}, errors.New("hold: only one piece can be held")
+			}
+			if len(pieces) == 1 {
+				pos.Hold, pos.HasHold = pieces[0], true
+			}
+		case "queue":
+			if len(pieces) > MaxPositionQueue {
+				return Position{}, fmt.Errorf("queue: at most %d pieces allowed", MaxPositionQueue)
+			}
+			pos.Queue = pieces
+		default:
+			return Position{}, fmt.Errorf("unknown header %q", strings.TrimSpace(key))
+		}
+	}
+	var err error
+	if pos.Board, err = ParseBoard(board.String()); err != nil {
+		return Position{}, err
+	}
+	return pos, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mustBoard parses a board in board notation, failing the test if it
+// isn't valid
+func mustBoard(t *testing.T, text string) *Board {
+	t.Helper()
+	b, err := ParseBoard(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPositionRoundTrip(t *testing.T) {
+	tests := []string{
+		"__________\n",
+		"hold: T\n_____#____\n",
+		"queue: IOSZ\n____T_____\n___TTT____\n",
+		"hold: L\nqueue: JLTIOSZ\n_______I__\nZZ_____I__\n#ZZ_SS_I__\n###SS##I##\n",
+	}
+	for _, text := range tests {
+		pos, err := ParsePosition(text)
+		if err != nil {
+			t.Errorf("ParsePosition(%q): %v", text, err)
+			continue
+		}
+		if got := FormatPosition(pos); got != text {
+			t.Errorf("round trip of %q gave %q", text, got)
+		}
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	pos, err := ParsePosition(`
+		hold: S
+		queue: T I O
+		__________
+		LLL_#_____
+		L##_######
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pos.HasHold || pos.Hold != PieceS {
+		t.Errorf("hold %v, want S", pos.Hold)
+	}
+	if want := []PieceType{PieceT, PieceI, PieceO}; !slices.Equal(pos.Queue, want) {
+		t.Errorf("queue %v, want %v", pos.Queue, want)
+	}
+	bottom := pos.Board.Cells[BoardHeight-1]
+	if !bottom[1].Filled || bottom[1].Color != ColorGarbage {
+		t.Error("# didn't parse as garbage")
+	}
+	if bottom[3].Filled {
+		t.Error("_ didn't parse as empty")
+	}
+	if bottom[0].Color != (&Piece{Type: PieceL}).Color() {
+		t.Error("L didn't parse in the L piece's color")
+	}
+	if pos.Board.Cells[BoardHeight-3] != (Board{}).Cells[0] {
+		t.Error("empty top row isn't empty")
+	}
+}
+
+func TestFormatBoardGarbage(t *testing.T) {
+	b := NewBoard()
+	b.Cells[BoardHeight-1][0] = NewFilledCell(ColorGarbage)
+	b.Cells[BoardHeight-1][1] = NewFilledCell((&Piece{Type: PieceZ}).Color())
+	if got, want := FormatBoard(b), "#Z________\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParsePositionMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"narrow row", "_________\n"},
+		{"wide row", "___________\n"},
+		{"unknown cell", "____X_____\n"},
+		{"too many rows", strings.Repeat("__________\n", BoardHeight+1)},
+		{"unknown header", "next: T\n__________\n"},
+		{"header after the board", "__________\nhold: T\n"},
+		{"two held pieces", "hold: TI\n__________\n"},
+		{"unknown queue piece", "queue: TIX\n__________\n"},
+		{"long queue", "queue: " + strings.Repeat("T", MaxPositionQueue+1) + "\n__________\n"},
+	}
+	for _, tt := range tests {
+		if _, err := ParsePosition(tt.text); err == nil {
+			t.Errorf("%s: ParsePosition(%q) succeeded", tt.name, tt.text)
+		}
+	}
+}
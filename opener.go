@@ -0,0 +1,403 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+)
+
+// An opener is a stack built in steps from the start of a game. Each
+// step is a board in board notation showing the stack once the step is
+// placed, before the lines it clears collapse. Cells already on the
+// board can be any letter; the rest are the step's pieces, a letter per
+// piece, so each piece appears at most once in a step. A step's hold:
+// header names the piece that must be in hold when it's done. Steps are
+// separated by --- lines, after a name: header:
+//
+//	name: Tetris Opener
+//	hold: I
+//	_____ZZ___
+//	...
+//	---
+//	...
+//
+// Lines starting with // are comments
+
+// openerStepSeparator is the line between an opener's steps
+const openerStepSeparator = "---"
+
+// OpenerStep is one step of an opener
+type OpenerStep struct {
+	Board   *Board    `json:"board"` // The stack with the step placed
+	Hold    PieceType `json:"hold"`  // Piece that must be held once the step is placed
+	HasHold bool      `json:"has_hold"`
+}
+
+// Opener is a sequence of steps to build
+type Opener struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	Steps []OpenerStep `json:"steps"`
+}
+
+// ParseOpener reads an opener file's text and checks the opener can be
+// built from some queue of 7-bags
+func ParseOpener(id, text string) (*Opener, error) {
+	o := &Opener{ID: id, Name: id}
+	var steps []string
+	var step strings.Builder
+	for line := range strings.Lines(text + "\n" + openerStepSeparator) {
+		trimmed := strings.TrimSpace(line)
+		switch key, value, _ := strings.Cut(trimmed, ":"); {
+		case strings.HasPrefix(trimmed, "//"):
+		case strings.EqualFold(strings.TrimSpace(key), "name"):
+			o.Name = strings.TrimSpace(value)
+		case trimmed == openerStepSeparator:
+			if strings.TrimSpace(step.String()) != "" {
+				steps = append(steps, step.String())
+			}
+			step.Reset()
+		default:
+			step.WriteString(line)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("opener has no steps")
+	}
+
+	for i, text := range steps {
+		pos, err := ParsePosition(text)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if len(pos.Queue) > 0 {
+			return nil, fmt.Errorf("step %d: steps have no queue", i+1)
+		}
+		o.Steps = append(o.Steps, OpenerStep{Board: pos.Board, Hold: pos.Hold, HasHold: pos.HasHold})
+	}
+	if _, err := o.Queue(NewOpenerMode(o), 0); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// starterOpeners is the opener pack built into the game
+//
+//go:embed openers/*.txt
+var starterOpeners embed.FS
+
+// StarterOpeners returns the built-in openers in order
+func StarterOpeners() ([]*Opener, error) {
+	sub, err := fs.Sub(starterOpeners, "openers")
+	if err != nil {
+		return nil, err
+	}
+	return loadTexts(sub, ParseOpener)
+}
+
+// LoadOpeners reads every .txt opener file in a directory, ordered by
+// file name, with IDs prefixed like LoadPuzzles. A missing directory
+// has no openers
+func LoadOpeners(dir string) ([]*Opener, error) {
+	openers, err := loadTexts(os.DirFS(dir), ParseOpener)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	for _, o := range openers {
+		o.ID = "user-" + o.ID
+	}
+	return openers, err
+}
+
+// targets returns the placements a step adds to a board, one for each
+// piece letter on the step's board where the board is empty
+func (s OpenerStep) targets(b *Board, system string) ([]Piece, error) {
+	var types []PieceType
+	cells := map[PieceType][]Offset{}
+	for row := range BoardHeight {
+		for col := range BoardWidth {
+			want, have := s.Board.Cells[row][col], b.Cells[row][col]
+			if have.Filled && !want.Filled {
+				return nil, errors.New("the stack from the steps before doesn't fit its board")
+			}
+			if have.Filled || !want.Filled {
+				continue
+			}
+			t, ok := colorPiece(want.Color)
+			if !ok {
+				return nil, errors.New("garbage can't be placed")
+			}
+			if cells[t] == nil {
+				types = append(types, t)
+			}
+			cells[t] = append(cells[t], Offset{Row: row, Col: col})
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("no pieces to place")
+	}
+
+	placements := make([]Piece, 0, len(types))
+	for _, t := range types {
+		p, ok := fitPiece(t, cells[t], system)
+		if !ok {
+			return nil, fmt.Errorf("the %s cells aren't a single %s", t, t)
+		}
+		placements = append(placements, p)
+	}
+	return placements, nil
+}
+
+// fitPiece finds the piece of a type that covers exactly the given
+// cells in the named rotation system
+func fitPiece(t PieceType, cells []Offset, system string) (Piece, bool) {
+	top, left := cells[0].Row, cells[0].Col
+	for _, cell := range cells {
+		top, left = min(top, cell.Row), min(left, cell.Col)
+	}
+	key := cellsKey(cells)
+	for r := Rotation0; r <= RotationL; r++ {
+		shape := pieceRotation(system, t).Shape(t, r)
+		shapeTop, shapeLeft := shape[0].Row, shape[0].Col
+		for _, offset := range shape {
+			shapeTop, shapeLeft = min(shapeTop, offset.Row), min(shapeLeft, offset.Col)
+		}
+		p := Piece{Type: t, Rotation: r, Row: top - shapeTop, Col: left - shapeLeft, System: system}
+		if placementKey(&p) == key {
+			return p, true
+		}
+	}
+	return Piece{}, false
+}
+
+// dropForClears moves placements down past the full rows below them,
+// which are about to collapse. A placement never overlaps a full row:
+// its cells are still empty
+func dropForClears(b *Board, placements []Piece) {
+	for i := range placements {
+		bottom := 0
+		for _, cell := range placements[i].Cells() {
+			bottom = max(bottom, cell.Row)
+		}
+		for row := bottom + 1; row < BoardHeight; row++ {
+			if b.rowFull(row) {
+				placements[i].Row++
+			}
+		}
+	}
+}
+
+// aimOpener sets the target for the piece that just spawned, starting
+// the next step if the last one is done. A piece with no target in the
+// step it can reach yet is to be held
+func (g *Game) aimOpener() {
+	steps := g.Mode.Opener.Steps
+	if len(g.OpenerLeft) == 0 && g.OpenerStep < len(steps) {
+		// Every step was checked against the stack when the opener was
+		// loaded, so it always fits
+		g.OpenerLeft, _ = steps[g.OpenerStep].targets(g.Board, g.Mode.Rotation)
+		g.OpenerStep++
+	}
+	g.Target = nil
+	for _, p := range g.OpenerLeft {
+		if p.Type == g.Current.Type && canReach(g.Board, g.Current, p, g.Mode.rotates180()) {
+			g.Target = &p
+			break
+		}
+	}
+}
+
+// checkOpener follows a locked piece through the opener: the game is
+// lost if it's not on one of the step's placements, or if the step is
+// then done without the piece it must leave in hold, and won once the
+// last step is done
+func (g *Game) checkOpener(placed *Piece) {
+	i := slices.IndexFunc(g.OpenerLeft, func(p Piece) bool {
+		return p.Type == placed.Type && placementKey(&p) == placementKey(placed)
+	})
+	if i < 0 {
+		deviation := *placed
+		g.Deviation = &deviation
+		g.Result = ResultFailed
+		return
+	}
+	g.Target = nil
+	g.OpenerLeft = slices.Delete(g.OpenerLeft, i, i+1)
+	dropForClears(g.Board, g.OpenerLeft)
+	if len(g.OpenerLeft) > 0 {
+		return
+	}
+
+	steps := g.Mode.Opener.Steps
+	if step := steps[g.OpenerStep-1]; step.HasHold && (!g.HasHold || g.Hold != step.Hold) {
+		g.Result = ResultFailed
+		return
+	}
+	if g.OpenerStep == len(steps) {
+		g.Result = ResultCleared
+	}
+}
+
+// MissedHold returns the piece the opener step needed in hold when the
+// game was lost by finishing the step without it
+func (g *Game) MissedHold() (PieceType, bool) {
+	if g.Mode.Opener == nil || g.Result != ResultFailed || g.Deviation != nil || g.OpenerStep == 0 {
+		return 0, false
+	}
+	step := g.Mode.Opener.Steps[g.OpenerStep-1]
+	return step.Hold, step.HasHold
+}
+
+// Queue deals a queue of whole 7-bags that the opener can be built
+// from in the mode, picked at random with the seed from all such queues
+func (o *Opener) Queue(mode Mode, seed uint64) ([]PieceType, error) {
+	b := &openerBuild{
+		opener: o,
+		mode:   mode,
+		rng:    rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+		failed: map[string]bool{},
+	}
+	queue := b.search(openerState{board: *NewBoard()})
+	if b.err != nil {
+		return nil, b.err
+	}
+	if queue == nil {
+		return nil, fmt.Errorf("%s can't be built from any %d bags", o.Name, MaxPositionQueue/len(Tetrominoes))
+	}
+	return queue, nil
+}
+
+// openerBuild searches for a queue an opener can be built from, trying
+// both placing and holding every piece dealt
+type openerBuild struct {
+	opener *Opener
+	mode   Mode
+	rng    *rand.Rand
+	failed map[string]bool // Keys of states no queue goes on from
+	err    error           // A step that doesn't fit the stack
+}
+
+// openerState is the game part way through an opener: the board only
+// depends on the steps and placements done, and the rest of the game
+// on the pieces dealt so far
+type openerState struct {
+	board    Board
+	step     int     // Steps begun
+	left     []Piece // Placements left in the step
+	current  PieceType
+	dealt    bool // current has been dealt and not yet placed
+	hold     PieceType
+	hasHold  bool
+	holdUsed bool
+	bag      []PieceType // Pieces left in the bag being dealt
+	queue    []PieceType
+}
+
+// key identifies a state for the failed states
+func (s openerState) key() string {
+	bag := slices.Clone(s.bag)
+	slices.Sort(bag)
+	var left []string
+	for _, p := range s.left {
+		left = append(left, placementKey(&p))
+	}
+	slices.Sort(left)
+	return fmt.Sprint(s.step, left, s.current, s.dealt, s.hold, s.hasHold, s.holdUsed, bag, len(s.queue))
+}
+
+// search returns the queue of the first way found to finish the opener
+// from a state, or nil if there is none
+func (b *openerBuild) search(s openerState) []PieceType {
+	if b.err != nil || b.failed[s.key()] {
+		return nil
+	}
+	queue := b.next(s)
+	if queue == nil {
+		b.failed[s.key()] = true
+	}
+	return queue
+}
+
+// next tries every way on from a state: dealing each piece left in the
+// bag, or placing or holding the piece dealt
+func (b *openerBuild) next(s openerState) []PieceType {
+	steps := b.opener.Steps
+	if len(s.left) == 0 {
+		if s.step > 0 {
+			if step := steps[s.step-1]; step.HasHold && (!s.hasHold || s.hold != step.Hold) {
+				return nil
+			}
+		}
+		if s.step == len(steps) {
+			// Deal the rest of the bag so the queue is whole bags
+			b.rng.Shuffle(len(s.bag), func(i, j int) { s.bag[i], s.bag[j] = s.bag[j], s.bag[i] })
+			return append(s.queue, s.bag...)
+		}
+		left, err := steps[s.step].targets(&s.board, b.mode.Rotation)
+		if err != nil {
+			b.err = fmt.Errorf("step %d: %w", s.step+1, err)
+			return nil
+		}
+		s.step++
+		s.left = left
+	}
+
+	if !s.dealt {
+		if len(s.bag) == 0 {
+			if len(s.queue)+len(Tetrominoes) > MaxPositionQueue {
+				return nil
+			}
+			s.bag = slices.Clone(Tetrominoes)
+		}
+		for _, i := range b.rng.Perm(len(s.bag)) {
+			dealt := s
+			dealt.current, dealt.dealt = s.bag[i], true
+			dealt.bag = slices.Delete(slices.Clone(s.bag), i, i+1)
+			dealt.queue = append(slices.Clone(s.queue), s.bag[i])
+			if queue := b.search(dealt); queue != nil {
+				return queue
+			}
+		}
+		return nil
+	}
+
+	for i, target := range s.left {
+		if target.Type != s.current || !canReach(&s.board, b.mode.spawnPiece(s.current), target, b.mode.rotates180()) {
+			continue
+		}
+		placed := s
+		placed.board.Lock(&target)
+		placed.left = slices.Delete(slices.Clone(s.left), i, i+1)
+		dropForClears(&placed.board, placed.left)
+		placed.board.ClearLines()
+		placed.dealt, placed.holdUsed = false, false
+		if queue := b.search(placed); queue != nil {
+			return queue
+		}
+	}
+
+	if s.holdUsed || b.mode.NoHold {
+		return nil
+	}
+	held := s
+	held.hold, held.hasHold, held.holdUsed = s.current, true, true
+	held.current, held.dealt = s.hold, s.hasHold
+	return b.search(held)
+}
+
+// canReach reports whether a piece can be moved from where it is into
+// a placement on the board and lock there, see searchPlacements
+func canReach(b *Board, start *Piece, target Piece, rotate180 bool) bool {
+	key := placementKey(&target)
+	reached := false
+	searchPlacements(b, start, rotate180, func(p Placement) bool {
+		reached = placementKey(&p.Piece) == key
+		return !reached
+	})
+	return reached
+}
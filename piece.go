@@ -1,6 +1,7 @@
 package main
 
-// PieceType represents one of the 7 standard Tetris pieces
+// PieceType represents one of the 7 standard Tetris pieces, or a
+// custom piece registered after them (see customPiece)
 type PieceType int
 
 const (
@@ -27,10 +28,16 @@ const (
 type Piece struct {
 	Type     PieceType
 	Rotation RotationState
-	Row      int // Top-left corner of bounding box
-	Col      int // Top-left corner of bounding box
+	Row      int    // Top-left corner of bounding box
+	Col      int    // Top-left corner of bounding box
+	System   string `json:",omitempty"` // Rotation system name ("" = SRS)
+	Big      bool   `json:",omitempty"` // Each mino is BigScale×BigScale cells
 }
 
+// BigScale is the width and height in board cells of a big piece's
+// minos. Big pieces move on a grid this many times coarser than the board
+const BigScale = 2
+
 // NewPiece creates a new piece at the specified position
 func NewPiece(pieceType PieceType, row, col int) *Piece {
 	return &Piece{
@@ -41,6 +48,50 @@ func NewPiece(pieceType PieceType, row, col int) *Piece {
 	}
 }
 
+// NewSpawnPiece creates a piece of the given type at the spawn
+// position of the named rotation system
+func NewSpawnPiece(pieceType PieceType, system string) *Piece {
+	row, col := pieceRotation(system, pieceType).Spawn(pieceType)
+	p := NewPiece(pieceType, row, col)
+	p.System = system
+	return p
+}
+
+// NewBigSpawnPiece creates a big piece of the given type, centered on
+// the big grid like a normal piece and with its top on the top row
+func NewBigSpawnPiece(pieceType PieceType, system string) *Piece {
+	p := NewSpawnPiece(pieceType, system)
+	p.Big = true
+	p.Col /= BigScale
+	top := BigScale
+	for _, offset := range pieceRotation(system, pieceType).Shape(pieceType, p.Rotation) {
+		top = min(top, offset.Row)
+	}
+	p.Row = -top
+	return p
+}
+
+// String returns the single-letter name of the piece type
+func (t PieceType) String() string {
+	if def := customPiece(t); def != nil {
+		return def.Name
+	}
+	names := []string{"I", "O", "T", "S", "Z", "J", "L"}
+	if t < 0 || int(t) >= len(names) {
+		return "?"
+	}
+	return names[t]
+}
+
+// String returns the guideline name of the rotation state (0, R, 2, L)
+func (r RotationState) String() string {
+	names := []string{"0", "R", "2", "L"}
+	if r < 0 || int(r) >= len(names) {
+		return "?"
+	}
+	return names[r]
+}
+
 // Color returns the color for this piece type
 func (p *Piece) Color() CellColor {
 	switch p.Type {
@@ -58,9 +109,11 @@ func (p *Piece) Color() CellColor {
 		return ColorBlue
 	case PieceL:
 		return ColorOrange
-	default:
-		return ColorEmpty
 	}
+	if def := customPiece(p.Type); def != nil {
+		return def.Color
+	}
+	return ColorEmpty
 }
 
 // Offset represents a (row, col) offset within a piece's bounding box
@@ -69,10 +122,10 @@ type Offset struct {
 	Col int
 }
 
-// pieceShapes defines the 4 cells for each piece at each rotation state
+// pieceShapes defines the 4 cells for each piece at each SRS rotation state
 // Coordinates are relative to the top-left of the bounding box
 // I piece uses 4×4 box, others use 3×3 box
-var pieceShapes = map[PieceType][4][4]Offset{
+var pieceShapes = [PieceL + 1][4][4]Offset{
 	PieceI: {
 		// State 0 (spawn): horizontal in middle of 4×4 box
 		{
@@ -179,19 +232,19 @@ var pieceShapes = map[PieceType][4][4]Offset{
 			{Row: 1, Col: 2}, // Center right
 			{Row: 2, Col: 2}, // Bottom right
 		},
-		// State 2: horizontal S (same as 0)
+		// State 2: horizontal S, one row lower than 0
 		{
-			{Row: 0, Col: 1},
-			{Row: 0, Col: 2},
-			{Row: 1, Col: 0},
 			{Row: 1, Col: 1},
+			{Row: 1, Col: 2},
+			{Row: 2, Col: 0},
+			{Row: 2, Col: 1},
 		},
-		// State L: vertical S (same as R)
+		// State L: vertical S, one column left of R
 		{
-			{Row: 0, Col: 1},
+			{Row: 0, Col: 0},
+			{Row: 1, Col: 0},
 			{Row: 1, Col: 1},
-			{Row: 1, Col: 2},
-			{Row: 2, Col: 2},
+			{Row: 2, Col: 1},
 		},
 	},
 
@@ -210,19 +263,19 @@ var pieceShapes = map[PieceType][4][4]Offset{
 			{Row: 1, Col: 2}, // Center right
 			{Row: 2, Col: 1}, // Bottom left
 		},
-		// State 2: horizontal Z (same as 0)
+		// State 2: horizontal Z, one row lower than 0
 		{
-			{Row: 0, Col: 0},
-			{Row: 0, Col: 1},
+			{Row: 1, Col: 0},
 			{Row: 1, Col: 1},
-			{Row: 1, Col: 2},
+			{Row: 2, Col: 1},
+			{Row: 2, Col: 2},
 		},
-		// State L: vertical Z (same as R)
+		// State L: vertical Z, one column left of R
 		{
-			{Row: 0, Col: 2},
+			{Row: 0, Col: 1},
+			{Row: 1, Col: 0},
 			{Row: 1, Col: 1},
-			{Row: 1, Col: 2},
-			{Row: 2, Col: 1},
+			{Row: 2, Col: 0},
 		},
 	},
 
@@ -289,22 +342,41 @@ var pieceShapes = map[PieceType][4][4]Offset{
 	},
 }
 
-// Cells returns the absolute board coordinates of the 4 cells
-// that make up this piece in its current rotation state
-func (p *Piece) Cells() [4]Offset {
-	offsets := pieceShapes[p.Type][p.Rotation]
-	var result [4]Offset
-
-	for i, offset := range offsets {
-		result[i] = Offset{
-			Row: p.Row + offset.Row,
-			Col: p.Col + offset.Col,
+// Cells returns the absolute board coordinates of the cells that make
+// up this piece in its current rotation state: 4 for a normal piece,
+// scaled up to 16 for a big one
+func (p *Piece) Cells() []Offset {
+	offsets := pieceRotation(p.System, p.Type).Shape(p.Type, p.Rotation)
+	if !p.Big {
+		result := make([]Offset, len(offsets))
+		for i, offset := range offsets {
+			result[i] = Offset{Row: p.Row + offset.Row, Col: p.Col + offset.Col}
 		}
+		return result
+	}
+	result := make([]Offset, 0, len(offsets)*BigScale*BigScale)
+	for _, offset := range offsets {
+		result = append(result, p.mino(offset)...)
 	}
-
 	return result
 }
 
+// mino returns the board cells covered by the mino at a shape offset:
+// a single cell, or a BigScale×BigScale block for a big piece
+func (p *Piece) mino(offset Offset) []Offset {
+	row, col := p.Row+offset.Row, p.Col+offset.Col
+	if !p.Big {
+		return []Offset{{Row: row, Col: col}}
+	}
+	cells := make([]Offset, 0, BigScale*BigScale)
+	for dr := 0; dr < BigScale; dr++ {
+		for dc := 0; dc < BigScale; dc++ {
+			cells = append(cells, Offset{Row: row*BigScale + dr, Col: col*BigScale + dc})
+		}
+	}
+	return cells
+}
+
 // WallKickOffset represents a wall kick test position
 type WallKickOffset struct {
 	Row int
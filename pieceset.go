@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Piece sets other than the 7 tetrominoes are made of custom pieces,
+// defined by their cells in each rotation state. Custom pieces are
+// registered after the tetrominoes, so their PieceType values start
+// right after PieceL
+
+// Piece set names used by Mode.Pieces
+const (
+	PieceSetTetromino = ""                   // The 7 tetrominoes, the default
+	PieceSetPentomino = "pentomino"          // The 18 one-sided pentominoes
+	PieceSetTriomino  = "tetromino+triomino" // The tetrominoes plus the 2 triominoes
+	PieceSetCustom    = "custom"             // Loaded from a file with LoadPieceSet
+)
+
+// MaxCustomPieces limits how many pieces a piece set file may define
+const MaxCustomPieces = 64
+
+// Tetrominoes are the standard 7 pieces in PieceType order
+var Tetrominoes = []PieceType{PieceI, PieceO, PieceT, PieceS, PieceZ, PieceJ, PieceL}
+
+// PieceDef defines a custom piece: its cells in each rotation state,
+// its color, where it spawns and the kicks it tries when turning
+type PieceDef struct {
+	Name      string                      `json:"name"`
+	Color     CellColor                   `json:"color"`
+	Box       int                         `json:"box"`              // Size of the square box the piece turns in
+	Cells     []Offset                    `json:"cells,omitempty"`  // Spawn state; the others are turned in the box
+	States    [][]Offset                  `json:"states,omitempty"` // All 4 states, instead of Cells
+	SpawnAt   *Offset                     `json:"spawn,omitempty"`  // Box position at spawn (default: centered on the top row)
+	KickTests map[string][]WallKickOffset `json:"kicks,omitempty"`  // Tests per turn, keyed like "0R" (default: SRS)
+}
+
+// customPieces are the registered custom pieces in PieceType order
+var customPieces []*PieceDef
+
+// pieceSets maps piece set names to the pieces dealt
+var pieceSets = map[string][]PieceType{
+	PieceSetTetromino: Tetrominoes,
+}
+
+func init() {
+	pentominoes, err := registerPieces(pentominoDefs)
+	if err != nil {
+		panic(err)
+	}
+	pieceSets[PieceSetPentomino] = pentominoes
+
+	triominoes, err := registerPieces(triominoDefs)
+	if err != nil {
+		panic(err)
+	}
+	pieceSets[PieceSetTriomino] = append(append([]PieceType{}, Tetrominoes...), triominoes...)
+}
+
+// pieceSet returns the pieces of the named set, defaulting to the
+// tetrominoes
+func pieceSet(name string) []PieceType {
+	if set, ok := pieceSets[name]; ok {
+		return set
+	}
+	return Tetrominoes
+}
+
+// customPiece returns the definition of a custom piece, or nil for the
+// tetrominoes
+func customPiece(t PieceType) *PieceDef {
+	i := int(t) - int(PieceL) - 1
+	if i < 0 || i >= len(customPieces) {
+		return nil
+	}
+	return customPieces[i]
+}
+
+// pieceRotation returns what shapes and turns a piece: its own
+// definition for custom pieces, otherwise the named rotation system
+func pieceRotation(system string, t PieceType) RotationSystem {
+	if def := customPiece(t); def != nil {
+		return def
+	}
+	return rotationSystem(system)
+}
+
+// registerPieces checks and registers custom pieces, returning their
+// piece types in order
+func registerPieces(defs []*PieceDef) ([]PieceType, error) {
+	for _, def := range defs {
+		if err := def.prepare(); err != nil {
+			return nil, err
+		}
+	}
+	types := make([]PieceType, 0, len(defs))
+	for _, def := range defs {
+		customPieces = append(customPieces, def)
+		types = append(types, PieceL+PieceType(len(customPieces)))
+	}
+	return types, nil
+}
+
+// pieceSetFile is the format of a piece set file
+type pieceSetFile struct {
+	Name        string      `json:"name"`
+	Tetrominoes bool        `json:"tetrominoes"` // Deal the 7 tetrominoes too
+	Pieces      []*PieceDef `json:"pieces"`
+}
+
+// LoadPieceSet reads piece definitions from a file and makes them the
+// custom piece set, returning the set's name
+func LoadPieceSet(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var file pieceSetFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", err
+	}
+	if file.Name == "" {
+		return "", errors.New("piece set has no name")
+	}
+	if len(file.Pieces) > MaxCustomPieces {
+		return "", fmt.Errorf("piece set has %d pieces, the most is %d", len(file.Pieces), MaxCustomPieces)
+	}
+	if len(file.Pieces) == 0 && !file.Tetrominoes {
+		return "", errors.New("piece set has no pieces")
+	}
+
+	pieces, err := registerPieces(file.Pieces)
+	if err != nil {
+		return "", err
+	}
+	if file.Tetrominoes {
+		pieces = append(append([]PieceType{}, Tetrominoes...), pieces...)
+	}
+	pieceSets[PieceSetCustom] = pieces
+	return file.Name, nil
+}
+
+// prepare checks a definition and fills in the turned states when only
+// the spawn state's cells are given
+func (d *PieceDef) prepare() error {
+	if d.Name == "" {
+		return errors.New("piece has no name")
+	}
+	if d.Color == ColorEmpty {
+		return fmt.Errorf("piece %s has no color", d.Name)
+	}
+	if d.Box < 1 || d.Box > BoardWidth {
+		return fmt.Errorf("piece %s: box must be 1 to %d", d.Name, BoardWidth)
+	}
+
+	if len(d.States) == 0 {
+		if len(d.Cells) == 0 {
+			return fmt.Errorf("piece %s has no cells", d.Name)
+		}
+		// Each state is the one before turned clockwise in the box
+		d.States = [][]Offset{d.Cells}
+		for r := 1; r < 4; r++ {
+			turned := make([]Offset, 0, len(d.Cells))
+			for _, cell := range d.States[r-1] {
+				turned = append(turned, Offset{Row: cell.Col, Col: d.Box - 1 - cell.Row})
+			}
+			d.States = append(d.States, turned)
+		}
+	}
+	if len(d.States) != 4 {
+		return fmt.Errorf("piece %s needs 4 states, has %d", d.Name, len(d.States))
+	}
+	for r, state := range d.States {
+		if len(state) == 0 {
+			return fmt.Errorf("piece %s state %s has no cells", d.Name, RotationState(r))
+		}
+		for _, cell := range state {
+			if cell.Row < 0 || cell.Row >= d.Box || cell.Col < 0 || cell.Col >= d.Box {
+				return fmt.Errorf("piece %s state %s: cell %d,%d is outside the box", d.Name, RotationState(r), cell.Row, cell.Col)
+			}
+		}
+	}
+
+	for key := range d.KickTests {
+		if _, _, ok := parseTurn(key); !ok {
+			return fmt.Errorf("piece %s: unknown kick turn %q", d.Name, key)
+		}
+	}
+	return nil
+}
+
+// parseTurn parses a turn written as two states, like "0R" or "2L"
+func parseTurn(turn string) (from, to RotationState, ok bool) {
+	if len(turn) != 2 {
+		return 0, 0, false
+	}
+	from, okFrom := parseRotation(turn[0])
+	to, okTo := parseRotation(turn[1])
+	return from, to, okFrom && okTo && from != to
+}
+
+// parseRotation parses a state written as 0, R, 2 or L
+func parseRotation(c byte) (RotationState, bool) {
+	for r := Rotation0; r <= RotationL; r++ {
+		if r.String()[0] == c {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// Shape returns the piece's cells in a state
+func (d *PieceDef) Shape(t PieceType, r RotationState) []Offset {
+	return d.States[r]
+}
+
+// Spawn returns the spawn position from the definition, or centers
+// the box with the piece's top on the top row
+func (d *PieceDef) Spawn(t PieceType) (row, col int) {
+	if d.SpawnAt != nil {
+		return d.SpawnAt.Row, d.SpawnAt.Col
+	}
+	top := d.Box
+	for _, cell := range d.States[Rotation0] {
+		top = min(top, cell.Row)
+	}
+	return -top, (BoardWidth - d.Box) / 2
+}
+
+// Target turns the piece through all four states
+func (d *PieceDef) Target(p *Piece, dir int) RotationState {
+	return quarterTurns(p.Rotation, dir)
+}
+
+// Kicks returns the definition's tests for the turn, or SRS's
+func (d *PieceDef) Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset {
+	if kicks, ok := d.KickTests[p.Rotation.String()+target.String()]; ok {
+		return kicks
+	}
+	return srsRotation{}.Kicks(b, p, target)
+}
+
+// kickTable converts a built-in kick table to PieceDef.KickTests form
+func kickTable(table map[RotationState]map[RotationState][5]WallKickOffset) map[string][]WallKickOffset {
+	kicks := map[string][]WallKickOffset{}
+	for from, turns := range table {
+		for to, tests := range turns {
+			kicks[from.String()+to.String()] = append([]WallKickOffset{}, tests[:]...)
+		}
+	}
+	return kicks
+}
+
+// pentominoDefs are the 18 one-sided pentominoes, with mirror images
+// as separate pieces
+var pentominoDefs = []*PieceDef{
+	{Name: "I5", Color: "#7fb4ca", Box: 5, Cells: []Offset{{2, 0}, {2, 1}, {2, 2}, {2, 3}, {2, 4}}, KickTests: kickTable(wallKicksI)},
+	{Name: "F", Color: "#d27e99", Box: 3, Cells: []Offset{{0, 1}, {0, 2}, {1, 0}, {1, 1}, {2, 1}}},
+	{Name: "F'", Color: "#c34043", Box: 3, Cells: []Offset{{0, 0}, {0, 1}, {1, 1}, {1, 2}, {2, 1}}},
+	{Name: "L5", Color: "#ffa066", Box: 4, Cells: []Offset{{0, 3}, {1, 0}, {1, 1}, {1, 2}, {1, 3}}},
+	{Name: "J5", Color: "#7e9cd8", Box: 4, Cells: []Offset{{0, 0}, {1, 0}, {1, 1}, {1, 2}, {1, 3}}},
+	{Name: "N", Color: "#98bb6c", Box: 4, Cells: []Offset{{0, 0}, {0, 1}, {1, 1}, {1, 2}, {1, 3}}},
+	{Name: "N'", Color: "#6a9589", Box: 4, Cells: []Offset{{0, 2}, {0, 3}, {1, 0}, {1, 1}, {1, 2}}},
+	{Name: "P", Color: "#e6c384", Box: 3, Cells: []Offset{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {1, 2}}},
+	{Name: "P'", Color: "#c0a36e", Box: 3, Cells: []Offset{{0, 1}, {0, 2}, {1, 0}, {1, 1}, {1, 2}}},
+	{Name: "T5", Color: "#957fb8", Box: 3, Cells: []Offset{{0, 0}, {0, 1}, {0, 2}, {1, 1}, {2, 1}}},
+	{Name: "U", Color: "#a3d4d5", Box: 3, Cells: []Offset{{0, 0}, {0, 2}, {1, 0}, {1, 1}, {1, 2}}},
+	{Name: "V", Color: "#7aa89f", Box: 3, Cells: []Offset{{0, 0}, {1, 0}, {2, 0}, {2, 1}, {2, 2}}},
+	{Name: "W", Color: "#938aa9", Box: 3, Cells: []Offset{{0, 0}, {1, 0}, {1, 1}, {2, 1}, {2, 2}}},
+	{Name: "X", Color: "#e82424", Box: 3, Cells: []Offset{{0, 1}, {1, 0}, {1, 1}, {1, 2}, {2, 1}}},
+	{Name: "Y", Color: "#dca561", Box: 4, Cells: []Offset{{0, 2}, {1, 0}, {1, 1}, {1, 2}, {1, 3}}},
+	{Name: "Y'", Color: "#ff5d62", Box: 4, Cells: []Offset{{0, 1}, {1, 0}, {1, 1}, {1, 2}, {1, 3}}},
+	{Name: "Z5", Color: "#e46876", Box: 3, Cells: []Offset{{0, 0}, {0, 1}, {1, 1}, {2, 1}, {2, 2}}},
+	{Name: "S5", Color: "#76946a", Box: 3, Cells: []Offset{{0, 1}, {0, 2}, {1, 1}, {2, 0}, {2, 1}}},
+}
+
+// triominoDefs are the straight and corner triominoes
+var triominoDefs = []*PieceDef{
+	{Name: "I3", Color: "#7fb4ca", Box: 3, Cells: []Offset{{1, 0}, {1, 1}, {1, 2}}},
+	{Name: "V3", Color: "#d27e99", Box: 2, Cells: []Offset{{0, 0}, {1, 0}, {1, 1}}},
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Placement is a place a piece can lock, with the fewest inputs that
+// put it there from where it started
+type Placement struct {
+	Piece Piece    // The piece as it locks
+	Spin  Spin     // T-spin the lock makes
+	Path  []Action // Inputs to get there, ending with ActionHardDrop
+}
+
+// placementInputs are the inputs the placement search tries, in order
+var placementInputs = []Action{
+	ActionLeft, ActionRight, ActionSoftDrop,
+	ActionRotateCW, ActionRotateCCW, ActionRotate180,
+}
+
+// placementNode is a state of the piece in the placement search. How
+// it got there matters as well as where it is, since a T locking after
+// a turn can make a T-spin
+type placementNode struct {
+	piece   Piece
+	rotated bool // The last input was a turn
+	tst     bool // The turn needed the TST kick
+}
+
+// placementState identifies a placementNode, or with spin a landing,
+// within one search, where the piece's type and system don't change
+type placementState struct {
+	row, col int
+	rotation RotationState
+	rotated  bool
+	tst      bool
+	spin     Spin
+}
+
+// state returns the node's placementState
+func (n placementNode) state() placementState {
+	return placementState{row: n.piece.Row, col: n.piece.Col, rotation: n.piece.Rotation, rotated: n.rotated, tst: n.tst}
+}
+
+// placementMargin is how far outside the board, in rows or columns, a
+// piece's box can be and still be searched
+const placementMargin = 8
+
+// placementIndex lays out a piece's position and rotation in a flat
+// slice over the board and placementMargin around it, returning -1 if
+// it's outside that
+func placementIndex(row, col int, rotation RotationState) int {
+	const width = BoardWidth + 2*placementMargin
+	row, col = row+placementMargin, col+placementMargin
+	if row < 0 || row >= BoardHeight+2*placementMargin || col < 0 || col >= width {
+		return -1
+	}
+	return (row*width+col)*4 + int(rotation)
+}
+
+// placementPositions is the length of a slice laid out by placementIndex
+const placementPositions = (BoardHeight + 2*placementMargin) * (BoardWidth + 2*placementMargin) * 4
+
+// placementSet is a set of placementStates, with a flag for each laid
+// out by placementIndex, which is much faster than a map for the
+// thousands of states a search goes through
+type placementSet []bool
+
+// newPlacementSet returns an empty placementSet
+func newPlacementSet() placementSet {
+	return make(placementSet, placementPositions*16)
+}
+
+// add adds a state to the set, returning false if it was already there
+// or is too far outside the board to be kept
+func (s placementSet) add(st placementState) bool {
+	i := placementIndex(st.row, st.col, st.rotation)
+	if i < 0 {
+		return false
+	}
+	flags := int(st.spin) << 2
+	if st.rotated {
+		flags |= 1
+	}
+	if st.tst {
+		flags |= 2
+	}
+	if s[i*16+flags] {
+		return false
+	}
+	s[i*16+flags] = true
+	return true
+}
+
+// placementDrops remembers the row each position and rotation in a
+// search lands on, laid out by placementIndex. Rows are stored counted
+// from placementMargin above the board and plus one, so zero is not
+// yet known. Every state above a landing lands there too, so each row
+// is tested once however many states drop past it
+type placementDrops []int
+
+// drop returns the piece moved straight down as far as it goes
+func (d placementDrops) drop(b *Board, p Piece) Piece {
+	start := p
+	for {
+		if i := placementIndex(p.Row, p.Col, p.Rotation); i >= 0 && d[i] > 0 {
+			p.Row = d[i] - 1 - placementMargin
+			break
+		}
+		test := p
+		test.Row++
+		if b.Collides(&test) {
+			break
+		}
+		p = test
+	}
+	for row := start.Row; row <= p.Row; row++ {
+		if i := placementIndex(row, p.Col, p.Rotation); i >= 0 {
+			d[i] = p.Row + placementMargin + 1
+		}
+	}
+	return p
+}
+
+// Placements returns every placement a piece of the given type can
+// reach from its spawn state on the board, under the mode's rotation
+// rules, in order of the length of their paths. See searchPlacements
+func Placements(b *Board, t PieceType, mode Mode) []Placement {
+	var placements []Placement
+	searchPlacements(b, mode.spawnPiece(t), mode.rotates180(), func(p Placement) bool {
+		placements = append(placements, p)
+		return true
+	})
+	return placements
+}
+
+// searchPlacements visits every placement a piece can reach from where
+// it is on the board, by shifts, single-row soft drops and turns with
+// the rotation system's kicks, then a hard drop. Tucks and spins are
+// found as well as straight drops. A breadth-first search over inputs
+// finds each placement first by a shortest path, and that's the one
+// visited; the same cells reached with a different spin are another
+// placement. The search stops when visit returns false
+func searchPlacements(b *Board, start *Piece, rotate180 bool, visit func(Placement) bool) {
+	if b.Collides(start) {
+		return
+	}
+	type searchStep struct {
+		node   placementNode
+		parent int // Index of the step before, -1 for the start
+		input  Action
+	}
+	steps := []searchStep{{node: placementNode{piece: *start}, parent: -1}}
+	seen, landings := newPlacementSet(), newPlacementSet()
+	drops := make(placementDrops, placementPositions)
+	seen.add(steps[0].node.state())
+	found := map[string]bool{}
+	for i := 0; i < len(steps); i++ {
+		n := steps[i].node
+
+		// A hard drop that moves the piece ends any spin
+		landed, spin := drops.drop(b, n.piece), SpinNone
+		if landed == n.piece {
+			kick := 0
+			if n.tst {
+				kick = tstKick
+			}
+			spin = spinOf(b, &landed, n.rotated, kick)
+		}
+		// Most states land where another already has, which is
+		// cheaper to spot than building the placement's key
+		if landings.add(placementState{row: landed.Row, col: landed.Col, rotation: landed.Rotation, spin: spin}) {
+			if key := placementKey(&landed) + spin.String(); !found[key] {
+				found[key] = true
+				path := []Action{ActionHardDrop}
+				for j := i; steps[j].parent >= 0; j = steps[j].parent {
+					path = append(path, steps[j].input)
+				}
+				slices.Reverse(path)
+				if !visit(Placement{Piece: landed, Spin: spin, Path: path}) {
+					return
+				}
+			}
+		}
+
+		for _, input := range placementInputs {
+			next := placementNode{piece: n.piece}
+			switch input {
+			case ActionLeft:
+				next.piece.Col--
+			case ActionRight:
+				next.piece.Col++
+			case ActionSoftDrop:
+				next.piece.Row++
+			default:
+				if input == ActionRotate180 && !rotate180 {
+					continue
+				}
+				turned, kick, ok := turnPiece(b, &n.piece, turnDirs[input])
+				if !ok {
+					continue
+				}
+				next = placementNode{piece: turned, rotated: true, tst: kick == tstKick}
+			}
+			if b.Collides(&next.piece) || !seen.add(next.state()) {
+				continue
+			}
+			steps = append(steps, searchStep{node: next, parent: i, input: input})
+		}
+	}
+}
+
+// routeTo finds the shortest inputs that move a piece from where it is
+// on the board into a placement, with the same spin, or false if it
+// can't get there
+func routeTo(b *Board, start *Piece, target *Placement, rotate180 bool) (Placement, bool) {
+	key := placementKey(&target.Piece)
+	var route Placement
+	found := false
+	searchPlacements(b, start, rotate180, func(p Placement) bool {
+		found = p.Spin == target.Spin && placementKey(&p.Piece) == key
+		route = p
+		return !found
+	})
+	return route, found
+}
+
+// describePath names the inputs of a placement's path, with repeats of
+// an input counted, such as "Left, Soft Drop x12, CW, Hard Drop"
+func describePath(path []Action) string {
+	var names []string
+	for i := 0; i < len(path); {
+		n := 1
+		for i+n < len(path) && path[i+n] == path[i] {
+			n++
+		}
+		name := path[i].String()
+		if n > 1 {
+			name = fmt.Sprintf("%s x%d", name, n)
+		}
+		names = append(names, name)
+		i += n
+	}
+	return strings.Join(names, ", ")
+}
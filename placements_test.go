@@ -0,0 +1,197 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// benchOverhangs is a board for BenchmarkPlacements with slots that
+// only tucks and spins reach
+const benchOverhangs = `
+__________
+__________
+___####___
+#___###_##
+##_####__#
+##_#####_#
+`
+
+// placementOf finds the placement that locks a piece into the cells
+// drawn in want but empty on start, with the given spin
+func placementOf(t *testing.T, placements []Placement, start *Board, want string, spin Spin) Placement {
+	t.Helper()
+	drawn := mustBoard(t, want)
+	var cells []Offset
+	for row := range BoardHeight {
+		for col := range BoardWidth {
+			if drawn.Cells[row][col].Filled && !start.Cells[row][col].Filled {
+				cells = append(cells, Offset{Row: row, Col: col})
+			}
+		}
+	}
+	for _, p := range placements {
+		if dRow, dCol, ok := matchCells(cells, p.Piece.Cells()); ok && dRow == 0 && dCol == 0 && p.Spin == spin {
+			return p
+		}
+	}
+	t.Fatalf("no %v placement into\n%s", spin, FormatBoard(drawn))
+	return Placement{}
+}
+
+// enginePath returns how many inputs the shortest way into a placement
+// takes, found by playing every input on a game rather than with
+// searchPlacements, or -1 if the game can't get there
+func enginePath(b *Board, mode Mode, target Placement) int {
+	type state struct {
+		piece   Piece
+		rotated bool
+		tst     bool
+	}
+	at := func(s state) *Game {
+		g := NewGameAt(mode, 1, Position{Board: b, Queue: []PieceType{target.Piece.Type}})
+		piece := s.piece
+		g.Current, g.rotated, g.lastKick = &piece, s.rotated, 0
+		if s.tst {
+			g.lastKick = tstKick
+		}
+		return g
+	}
+
+	start := state{piece: *NewGameAt(mode, 1, Position{Board: b, Queue: []PieceType{target.Piece.Type}}).Current}
+	seen := map[state]bool{start: true}
+	for depth, level := 1, []state{start}; len(level) > 0; depth++ {
+		var next []state
+		for _, s := range level {
+			g := at(s)
+			landed := *g.Current
+			landed.Row += g.DropDistance()
+			if g.Apply(ActionHardDrop); placementKey(&landed) == placementKey(&target.Piece) && g.LastSpin == target.Spin {
+				return depth
+			}
+			for _, input := range placementInputs {
+				g := at(s)
+				g.Apply(input)
+				moved := state{piece: *g.Current, rotated: g.rotated, tst: g.rotated && g.lastKick == tstKick}
+				if !seen[moved] {
+					seen[moved] = true
+					next = append(next, moved)
+				}
+			}
+		}
+		level = next
+	}
+	return -1
+}
+
+// checkPlacement checks that a placement's path is as short as any
+// the game allows, and that playing it locks the piece with its spin
+func checkPlacement(t *testing.T, b *Board, mode Mode, p Placement) {
+	t.Helper()
+	if want := enginePath(b, mode, p); len(p.Path) != want {
+		t.Errorf("path %s takes %d inputs, the game's shortest %d", describePath(p.Path), len(p.Path), want)
+	}
+	g := NewGameAt(mode, 1, Position{Board: b, Queue: []PieceType{p.Piece.Type}})
+	for _, input := range p.Path {
+		g.Apply(input)
+	}
+	if g.Pieces != 1 || g.LastSpin != p.Spin {
+		t.Errorf("playing %s locked %d pieces with %v, want 1 with %v", describePath(p.Path), g.Pieces, g.LastSpin, p.Spin)
+	}
+}
+
+func TestPlacementsTSpinSlot(t *testing.T) {
+	start := mustBoard(t, `
+		___#______
+		###___####
+		####_#####
+	`)
+	mode := NewMarathonMode(1)
+	p := placementOf(t, Placements(start, PieceT, mode), start, `
+		___#______
+		###TTT####
+		####T#####
+	`, SpinFull)
+	checkPlacement(t, start, mode, p)
+
+	// A straight drop can't get under the overhang
+	if !slices.Contains(p.Path, ActionRotateCW) && !slices.Contains(p.Path, ActionRotateCCW) {
+		t.Errorf("path %s has no turn into the slot", describePath(p.Path))
+	}
+}
+
+func TestPlacementsTuck(t *testing.T) {
+	start := mustBoard(t, `
+		#####_____
+		__________
+		__________
+	`)
+	mode := NewMarathonMode(1)
+	p := placementOf(t, Placements(start, PieceO, mode), start, `
+		#####_____
+		____OO____
+		____OO____
+	`, SpinNone)
+	checkPlacement(t, start, mode, p)
+	if last := p.Path[len(p.Path)-2]; last != ActionLeft {
+		t.Errorf("path %s doesn't end by tucking left", describePath(p.Path))
+	}
+}
+
+func TestPlacements180(t *testing.T) {
+	start := mustBoard(t, `
+		###____###
+		#_#____###
+		#___######
+	`)
+	want := `
+		###J___###
+		#_#J___###
+		#_JJ######
+	`
+	mode := NewMarathonMode(1)
+	mode.Rotation = RotationSRSPlus
+	p := placementOf(t, Placements(start, PieceJ, mode), start, want, SpinNone)
+	checkPlacement(t, start, mode, p)
+	if !slices.Contains(p.Path, ActionRotate180) {
+		t.Errorf("path %s has no 180° turn", describePath(p.Path))
+	}
+
+	var quarter []Placement
+	searchPlacements(start, mode.spawnPiece(PieceJ), false, func(p Placement) bool {
+		quarter = append(quarter, p)
+		return true
+	})
+	for _, q := range quarter {
+		if placementKey(&q.Piece) == placementKey(&p.Piece) {
+			t.Errorf("reached by quarter turns alone: %s", describePath(q.Path))
+		}
+	}
+}
+
+func BenchmarkPlacements(b *testing.B) {
+	garbage := NewBoard()
+	gen := NewGarbageGenerator(1, 0.5)
+	for range 10 {
+		garbage.AddGarbageRow(gen.NextHole())
+	}
+	overhangs, err := ParseBoard(benchOverhangs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	boards := []struct {
+		name  string
+		board *Board
+	}{{"empty", NewBoard()}, {"garbage", garbage}, {"overhangs", overhangs}}
+
+	mode := NewMarathonMode(1)
+	for _, board := range boards {
+		for _, t := range Tetrominoes {
+			b.Run(board.name+"/"+t.String(), func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					Placements(board.board, t, mode)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A puzzle is a position in board notation with a goal to reach using
+// only its pieces, within a piece limit. Puzzle headers go before the
+// position's own:
+//
+//	name: First T-Spin Double
+//	goal: tsd
+//	pieces: 1
+//	queue: T
+//	####______
+//	###___####
+//	####_#####
+//
+// Lines starting with // are comments
+
+// Goal is a puzzle objective, named as in puzzle files
+type Goal string
+
+const (
+	GoalNone         Goal = ""
+	GoalPerfectClear Goal = "pc"     // Clear every filled cell off the board
+	GoalLines        Goal = "lines"  // Clear Mode.GoalLines lines
+	GoalTetris       Goal = "tetris" // Clear four lines with one piece
+	GoalTSS          Goal = "tss"    // T-spin single
+	GoalTSD          Goal = "tsd"    // T-spin double
+	GoalTST          Goal = "tst"    // T-spin triple
+)
+
+// goalSpinLines are the lines the T-spin goals must clear
+var goalSpinLines = map[Goal]int{GoalTSS: 1, GoalTSD: 2, GoalTST: 3}
+
+// Puzzle is a position to solve
+type Puzzle struct {
+	ID         string // Identifies the puzzle in the progress file
+	Name       string
+	Goal       Goal
+	GoalLines  int // Lines to clear for GoalLines
+	PieceLimit int // Most pieces that may be placed (0 = as many as the queue holds)
+	Position
+}
+
+// Objective describes the puzzle's goal and piece limit
+func (p Puzzle) Objective() string {
+	var goal string
+	switch p.Goal {
+	case GoalPerfectClear:
+		goal = "Clear the whole board"
+	case GoalLines:
+		goal = fmt.Sprintf("Clear %d lines", p.GoalLines)
+	case GoalTetris:
+		goal = "Clear four lines at once"
+	case GoalTSS:
+		goal = "Perform a T-spin single"
+	case GoalTSD:
+		goal = "Perform a T-spin double"
+	case GoalTST:
+		goal = "Perform a T-spin triple"
+	}
+	switch p.PieceLimit {
+	case 0:
+		return goal
+	case 1:
+		return goal + " with one piece"
+	}
+	return fmt.Sprintf("%s in %d pieces", goal, p.PieceLimit)
+}
+
+// goalLabel is a short name for a mode's goal, for the stats panel
+func goalLabel(mode Mode) string {
+	switch mode.Goal {
+	case GoalPerfectClear:
+		return "PC"
+	case GoalLines:
+		return fmt.Sprintf("%d lines", mode.GoalLines)
+	case GoalTetris:
+		return "Tetris"
+	}
+	return strings.ToUpper(string(mode.Goal))
+}
+
+// ParsePuzzle reads a puzzle file's text
+func ParsePuzzle(id, text string) (Puzzle, error) {
+	p := Puzzle{ID: id, Name: id}
+	var position strings.Builder
+	for line := range strings.Lines(text) {
+		if strings.HasPrefix(strings.TrimSpace(line), "//") {
+			continue
+		}
+		key, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name":
+			p.Name = value
+		case "goal":
+			p.Goal, p.GoalLines, err = parseGoal(value)
+		case "pieces":
+			if p.PieceLimit, err = strconv.Atoi(value); err == nil && p.PieceLimit < 1 {
+				err = errors.New("must be at least 1")
+			}
+		default:
+			position.WriteString(line)
+		}
+		if err != nil {
+			return Puzzle{}, fmt.Errorf("%s: %w", strings.TrimSpace(key), err)
+		}
+	}
+	if p.Goal == GoalNone {
+		return Puzzle{}, errors.New("puzzle has no goal")
+	}
+
+	var err error
+	if p.Position, err = ParsePosition(position.String()); err != nil {
+		return Puzzle{}, err
+	}
+	if len(p.Queue) == 0 {
+		return Puzzle{}, errors.New("puzzle has no queue")
+	}
+	return p, nil
+}
+
+// parseGoal reads a goal header: a goal name, followed by the number
+// of lines for GoalLines
+func parseGoal(text string) (Goal, int, error) {
+	name, count, _ := strings.Cut(strings.ToLower(text), " ")
+	goal := Goal(name)
+	switch goal {
+	case GoalLines:
+		lines, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || lines < 1 {
+			return GoalNone, 0, fmt.Errorf("%q needs a number of lines", text)
+		}
+		return goal, lines, nil
+	case GoalPerfectClear, GoalTetris, GoalTSS, GoalTSD, GoalTST:
+		return goal, 0, nil
+	}
+	return GoalNone, 0, fmt.Errorf("unknown goal %q", text)
+}
+
+// starterPuzzles is the puzzle pack built into the game
+//
+//go:embed puzzles/*.txt
+var starterPuzzles embed.FS
+
+// StarterPuzzles returns the built-in puzzles in order
+func StarterPuzzles() ([]Puzzle, error) {
+	sub, err := fs.Sub(starterPuzzles, "puzzles")
+	if err != nil {
+		return nil, err
+	}
+	return loadTexts(sub, ParsePuzzle)
+}
+
+// LoadPuzzles reads every .txt puzzle file in a directory, ordered by
+// file name. Their IDs are their file names prefixed with "user-", so
+// they can't clash with the built-in puzzles. A missing directory has
+// no puzzles
+func LoadPuzzles(dir string) ([]Puzzle, error) {
+	puzzles, err := loadTexts(os.DirFS(dir), ParsePuzzle)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	for i := range puzzles {
+		puzzles[i].ID = "user-" + puzzles[i].ID
+	}
+	return puzzles, err
+}
+
+// loadTexts parses the .txt files at the top of fsys, in file name
+// order, each with the file name without the extension as its ID
+func loadTexts[T any](fsys fs.FS, parse func(id, text string) (T, error)) ([]T, error) {
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		return nil, err
+	}
+	names, err := fs.Glob(fsys, "*.txt")
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return items, err
+		}
+		item, err := parse(strings.TrimSuffix(name, ".txt"), string(data))
+		if err != nil {
+			return items, fmt.Errorf("%s: %w", name, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// checkGoal ends a game with a goal after a lock that cleared the
+// given number of lines: won if the lock reached the goal, lost if it
+// used up the piece limit without reaching it
+func (g *Game) checkGoal(cleared int) {
+	if g.goalReached(cleared) {
+		g.Result = ResultCleared
+		return
+	}
+	if g.Mode.PieceLimit > 0 && g.Pieces >= g.Mode.PieceLimit {
+		g.Result = ResultFailed
+	}
+}
+
+// goalReached reports whether the lock that just happened, clearing
+// the given number of lines, reached the mode's goal
+func (g *Game) goalReached(cleared int) bool {
+	switch g.Mode.Goal {
+	case GoalPerfectClear:
+		// Every row left is either full, so about to be cleared, or empty
+		for row := range BoardHeight {
+			for col := range BoardWidth {
+				if g.Board.Cells[row][col].Filled && !g.Board.rowFull(row) {
+					return false
+				}
+			}
+		}
+		return cleared > 0
+	case GoalLines:
+		return g.Lines >= g.Mode.GoalLines
+	case GoalTetris:
+		return cleared == 4
+	case GoalTSS, GoalTSD, GoalTST:
+		return g.LastSpin == SpinFull && cleared == goalSpinLines[g.Mode.Goal]
+	}
+	return false
+}
+
+// PuzzleRecord is the best solve of a puzzle
+type PuzzleRecord struct {
+	Pieces int       `json:"pieces"` // Fewest pieces placed
+	Frames int       `json:"frames"` // Fastest time
+	Date   time.Time `json:"date"`   // First solved
+}
+
+// PuzzleProgress holds the puzzles solved, keyed by Puzzle.ID
+type PuzzleProgress map[string]PuzzleRecord
+
+// LoadPuzzleProgress reads the puzzle progress file
+// A missing file is not an error and yields no progress
+func LoadPuzzleProgress(path string) (PuzzleProgress, error) {
+	progress := PuzzleProgress{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, err
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return PuzzleProgress{}, err
+	}
+	return progress, nil
+}
+
+// Save writes the puzzle progress file, creating its directory if
+// needed
+func (p PuzzleProgress) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Solve records a solved puzzle game, keeping the fewest pieces and
+// fastest time of all its solves
+func (p PuzzleProgress) Solve(g *Game) {
+	record, ok := p[g.Mode.Puzzle]
+	if !ok {
+		p[g.Mode.Puzzle] = PuzzleRecord{Pieces: g.Pieces, Frames: g.Frame, Date: time.Now()}
+		return
+	}
+	record.Pieces = min(record.Pieces, g.Pieces)
+	record.Frames = min(record.Frames, g.Frame)
+	p[g.Mode.Puzzle] = record
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestPuzzleSolvedWithHeldPiece(t *testing.T) {
+	// The T is only in hold, and comes out once the I has been played
+	p, err := ParsePuzzle("held", `
+		goal: pc
+		pieces: 2
+		hold: T
+		queue: I
+		#########_
+		#########_
+		___######_
+		#_#######_
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGameAt(NewPuzzleMode(p), 1, p.Position)
+	for _, action := range []Action{ActionRotateCW, ActionDASRight, ActionHardDrop} {
+		g.Apply(action)
+	}
+	if g.Over() || g.Current == nil || g.Current.Type != PieceT || g.HasHold {
+		t.Fatalf("held T wasn't spawned after the queue ran out: %v", g.Result)
+	}
+	for _, action := range []Action{ActionRotateCW, ActionRotateCW, ActionDASLeft, ActionHardDrop} {
+		g.Apply(action)
+	}
+	if g.Result != ResultCleared {
+		t.Errorf("puzzle ended with %v, want solved\n%s", g.Result, FormatBoard(g.Board))
+	}
+}
+
+func TestPuzzleFailsWithNothingLeft(t *testing.T) {
+	p, err := ParsePuzzle("empty", `
+		goal: tetris
+		queue: O
+		__________
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGameAt(NewPuzzleMode(p), 1, p.Position)
+	g.Apply(ActionHold)
+	if g.Over() || g.Current == nil || g.Current.Type != PieceO {
+		t.Fatal("hold with nothing to swap in changed the piece")
+	}
+	g.Apply(ActionHardDrop)
+	if g.Result != ResultFailed {
+		t.Errorf("puzzle ended with %v, want failed with no pieces left", g.Result)
+	}
+}
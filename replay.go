@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A replay is a game's seed, its rules and every input with the frame
+// it happened on. The engine is deterministic, so playing the inputs
+// back into a new game with the same seed and rules repeats the game
+// exactly. Every replay carries a hash of the final state, checked by
+// replaying it before it is saved and again when playback finishes
+
+// ReplayVersion is the version of the replay file format
+const ReplayVersion = 1
+
+// ErrReplayDesync is returned when replaying a recording doesn't end
+// in the recorded final state
+var ErrReplayDesync = errors.New("replay doesn't reproduce the recorded game")
+
+// inputKind is how an input was given to the game
+type inputKind int
+
+const (
+	inputApply   inputKind = iota // Game.Apply
+	inputPress                    // Game.Press
+	inputRelease                  // Game.Release
+)
+
+// inputKindShift packs an input kind and action into one code
+const inputKindShift = 4
+
+// Replay is a recorded game
+type Replay struct {
+	Version int       `json:"version"`
+	Seed    uint64    `json:"seed"`
+	Mode    Mode      `json:"mode"`
+	Date    time.Time `json:"date"`
+	Frames  int       `json:"frames"`          // Length of the game
+	Hash    string    `json:"hash"`            // StateHash at the end of the game
+	Start   *Position `json:"start,omitempty"` // Position the game started from, nil for an empty board
+
+	// Inputs are pairs of frames since the previous input and the
+	// input's code: its kind shifted by inputKindShift, plus its action
+	Inputs []int `json:"inputs"`
+
+	lastFrame int // Frame of the last recorded input
+}
+
+// replayInput is one recorded input
+type replayInput struct {
+	frame  int
+	kind   inputKind
+	action Action
+}
+
+// StartRecording begins recording the game's inputs into a replay
+// It must be called before any input, with the seed and position the
+// game was created with
+func (g *Game) StartRecording(seed uint64, start Position) {
+	g.recording = &Replay{
+		Version: ReplayVersion,
+		Seed:    seed,
+		Mode:    g.Mode,
+		Date:    time.Now(),
+	}
+	if start.Board != nil || len(start.Queue) > 0 || start.HasHold {
+		g.recording.Start = &start
+	}
+}
+
+// Recording returns the replay of the game so far, or nil if it isn't
+// being recorded. Once the game is over the replay is complete, with
+// its length and final state hash filled in
+func (g *Game) Recording() *Replay {
+	if g.recording != nil && g.Over() {
+		g.recording.Frames = g.Frame
+		g.recording.Hash = g.StateHash()
+	}
+	return g.recording
+}
+
+// record adds an input to the recording, if there is one
+func (g *Game) record(kind inputKind, action Action) {
+	r := g.recording
+	if r == nil || g.Over() {
+		return
+	}
+	r.Inputs = append(r.Inputs, g.Frame-r.lastFrame, int(kind)<<inputKindShift|int(action))
+	r.lastFrame = g.Frame
+}
+
+// resume prepares a replay loaded with a saved game to record the
+// rest of it
+func (r *Replay) resume() error {
+	inputs, err := r.inputs()
+	if err != nil {
+		return err
+	}
+	r.lastFrame = 0
+	if len(inputs) > 0 {
+		r.lastFrame = inputs[len(inputs)-1].frame
+	}
+	return nil
+}
+
+// StateHash returns a hash of the board, score and progress, used to
+// check a replay ends exactly where the recorded game did
+func (g *Game) StateHash() string {
+	h := fnv.New64a()
+	for row := 0; row < BoardHeight; row++ {
+		for col := 0; col < BoardWidth; col++ {
+			cell := g.Board.Cells[row][col]
+			if cell.Filled {
+				fmt.Fprintf(h, "%d,%d:%s;", row, col, cell.Color)
+			}
+		}
+	}
+	fmt.Fprintf(h, "%d/%d/%d/%d/%d/%d", g.Score, g.Lines, g.Level, g.Frame, g.Pieces, g.Result)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// inputs decodes the recorded inputs
+func (r *Replay) inputs() ([]replayInput, error) {
+	if len(r.Inputs)%2 != 0 {
+		return nil, errors.New("replay inputs are incomplete")
+	}
+	inputs := make([]replayInput, 0, len(r.Inputs)/2)
+	frame := 0
+	for i := 0; i < len(r.Inputs); i += 2 {
+		frame += r.Inputs[i]
+		code := r.Inputs[i+1]
+		kind := inputKind(code >> inputKindShift)
+		action := Action(code & (1<<inputKindShift - 1))
+		if r.Inputs[i] < 0 || kind > inputRelease || action > ActionDASRight {
+			return nil, fmt.Errorf("replay input %d is not valid", i/2)
+		}
+		inputs = append(inputs, replayInput{frame: frame, kind: kind, action: action})
+	}
+	return inputs, nil
+}
+
+// Verify plays the replay from the start and checks it ends in the
+// recorded final state
+func (r *Replay) Verify() error {
+	p, err := NewReplayPlayer(r)
+	if err != nil {
+		return err
+	}
+	p.Seek(r.Frames)
+	return p.Check()
+}
+
+// SaveReplay writes a replay to path, creating its directory if needed
+func SaveReplay(path string, r *Replay) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadReplay reads a replay saved with SaveReplay
+func LoadReplay(path string) (*Replay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Replay
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("replay is version %d, expected %d", r.Version, ReplayVersion)
+	}
+	if _, ok := pieceSets[r.Mode.Pieces]; !ok {
+		return nil, fmt.Errorf("replay needs piece set %q, which is not loaded", r.Mode.Pieces)
+	}
+	if r.Start != nil && r.Start.Board == nil {
+		return nil, errors.New("replay start position has no board")
+	}
+	return &r, nil
+}
+
+// replayPath returns the file a finished game's replay is saved to in
+// the gotetris data directory, named after its mode and date
+func replayPath(r *Replay) (string, error) {
+	name := fmt.Sprintf("%s-%s.json", fileName(r.Mode.Key()), r.Date.Format("20060102-150405"))
+	return dataPath(filepath.Join("replays", name))
+}
+
+// fileName makes a mode key safe to use in a file name: a custom
+// piece set's name is whatever its file says, so anything but letters,
+// digits, - and _ becomes _
+func fileName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// ReplayPlayer plays a replay back frame by frame
+// Seeking backwards replays from the start, which is fast enough that
+// no snapshots are needed
+type ReplayPlayer struct {
+	Replay *Replay
+	Game   *Game
+
+	inputs []replayInput
+	next   int // Index of the next input to apply
+}
+
+// NewReplayPlayer creates a player at the start of the replay
+func NewReplayPlayer(r *Replay) (*ReplayPlayer, error) {
+	inputs, err := r.inputs()
+	if err != nil {
+		return nil, err
+	}
+	p := &ReplayPlayer{Replay: r, inputs: inputs}
+	p.restart()
+	return p, nil
+}
+
+// restart goes back to the first frame
+func (p *ReplayPlayer) restart() {
+	var start Position
+	if p.Replay.Start != nil {
+		start = *p.Replay.Start
+	}
+	p.Game = NewGameAt(p.Replay.Mode, p.Replay.Seed, start)
+	p.next = 0
+}
+
+// Done reports whether the whole replay has been played
+func (p *ReplayPlayer) Done() bool {
+	return p.Game.Over() || p.Game.Frame >= p.Replay.Frames
+}
+
+// Step plays one frame: the inputs recorded on the current frame,
+// then the engine's step to the next
+func (p *ReplayPlayer) Step() {
+	p.applyInputs()
+	if !p.Done() {
+		p.Game.Step()
+	}
+	if p.Done() {
+		// Inputs after the last step, like the drop that ended the game
+		p.applyInputs()
+	}
+}
+
+// applyInputs gives the game every input recorded on its current frame
+func (p *ReplayPlayer) applyInputs() {
+	g := p.Game
+	for p.next < len(p.inputs) && p.inputs[p.next].frame <= g.Frame {
+		input := p.inputs[p.next]
+		switch input.kind {
+		case inputApply:
+			g.Apply(input.action)
+		case inputPress:
+			g.Press(input.action)
+		case inputRelease:
+			g.Release(input.action)
+		}
+		p.next++
+	}
+}
+
+// Seek moves to a frame, replaying from the start if it is behind the
+// current one
+func (p *ReplayPlayer) Seek(frame int) {
+	frame = min(max(frame, 0), p.Replay.Frames)
+	if frame < p.Game.Frame {
+		p.restart()
+	}
+	for p.Game.Frame < frame && !p.Done() {
+		p.Step()
+	}
+	if frame == p.Replay.Frames {
+		p.applyInputs()
+	}
+}
+
+// Check reports whether a finished playback reproduced the recorded
+// game, returning ErrReplayDesync if it didn't
+func (p *ReplayPlayer) Check() error {
+	if p.Game.StateHash() != p.Replay.Hash {
+		return ErrReplayDesync
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// replayStart is the position the recorded test game starts from
+const replayStart = `
+queue: TIL
+_____#____
+##_#######
+`
+
+// recordTestGame plays a seeded Ultra game to its end with a fixed
+// script of taps, held shifts, rotations, holds and drops, recording it
+func recordTestGame(t *testing.T) *Game {
+	t.Helper()
+	pos, err := ParsePosition(replayStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const seed = 42
+	g := NewGameAt(NewUltraMode(20*time.Second), seed, pos)
+	g.StartRecording(seed, pos)
+
+	script := [][]Action{
+		{ActionLeft, ActionRotateCW},
+		{ActionDASRight},
+		{ActionHold},
+		{ActionRotateCCW, ActionRight, ActionRight},
+		{ActionDASLeft, ActionRotateCW},
+		{ActionRight},
+	}
+	for piece := 0; !g.Over(); piece++ {
+		for _, action := range script[piece%len(script)] {
+			g.Apply(action)
+			g.Step()
+		}
+		if piece%3 == 0 {
+			// Hold a shift for a few frames so auto-shift is recorded
+			g.Press(ActionLeft)
+			for range 12 {
+				g.Step()
+			}
+			g.Release(ActionLeft)
+		}
+		for range piece % 4 {
+			g.Apply(ActionSoftDrop)
+			g.Step()
+		}
+		g.Apply(ActionHardDrop)
+		for !g.Over() && g.Phase != PhaseFalling {
+			g.Step()
+		}
+	}
+	return g
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	g := recordTestGame(t)
+	recorded := g.Recording()
+	if recorded.Frames != g.Frame || recorded.Hash != g.StateHash() {
+		t.Fatal("finished recording has no final state")
+	}
+	if g.Pieces < 10 {
+		t.Fatalf("only %d pieces were played", g.Pieces)
+	}
+
+	path := filepath.Join(t.TempDir(), "replay.json")
+	if err := SaveReplay(path, recorded); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewReplayPlayer(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Seek(loaded.Frames / 2)
+	middle := p.Game.StateHash()
+	p.Seek(loaded.Frames)
+	if !p.Done() {
+		t.Fatal("playback isn't done at the last frame")
+	}
+	if got := p.Game.StateHash(); got != g.StateHash() {
+		t.Errorf("playback ends in state %s, recorded %s", got, g.StateHash())
+	}
+	if err := p.Check(); err != nil {
+		t.Error(err)
+	}
+
+	// Seeking back replays from the start to the same state
+	p.Seek(loaded.Frames / 2)
+	if p.Game.StateHash() != middle {
+		t.Error("seeking back reached a different state")
+	}
+}
+
+func TestReplayDesync(t *testing.T) {
+	r := recordTestGame(t).Recording()
+	// Turn the first input into a hold
+	r.Inputs[1] = int(inputApply)<<inputKindShift | int(ActionHold)
+	if err := r.Verify(); !errors.Is(err, ErrReplayDesync) {
+		t.Errorf("got %v, want ErrReplayDesync", err)
+	}
+}
+
+func TestReplayPathCustomName(t *testing.T) {
+	r := &Replay{
+		Mode: NewPieceSetMode(ModeCustom, "../../my pieces/ü", PieceSetCustom, 1),
+		Date: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	path, err := replayPath(r)
+	if err != nil {
+		t.Skip(err)
+	}
+	if filepath.Base(filepath.Dir(path)) != "replays" {
+		t.Errorf("replay saved outside the replays directory: %s", path)
+	}
+	if got, want := filepath.Base(path), "custom-______my_pieces__-20250102-030405.json"; got != want {
+		t.Errorf("replay saved as %s, want %s", got, want)
+	}
+}
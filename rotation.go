@@ -0,0 +1,448 @@
+package main
+
+// RotationSystem decides how pieces look and turn: the cells of each
+// piece in each state, where pieces spawn, the state a rotation ends in
+// and the offsets tried, in order, when the turned piece doesn't fit
+type RotationSystem interface {
+	// Shape returns the cells of a piece in a state, relative to the
+	// top-left of its bounding box and in reading order. The slice is
+	// shared and must not be changed
+	Shape(t PieceType, r RotationState) []Offset
+
+	// Spawn returns the board position of a new piece's bounding box
+	Spawn(t PieceType) (row, col int)
+
+	// Target returns the state p ends in after dir quarter turns
+	// (1 = clockwise, -1 = counter-clockwise, 2 = 180°)
+	Target(p *Piece, dir int) RotationState
+
+	// Kicks returns the offsets to try when rotating p to target on
+	// the board. No offsets means the rotation isn't allowed
+	Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset
+}
+
+// Rotation system names used by Mode.Rotation
+const (
+	RotationSRS     = "srs"  // Super Rotation System (guideline), the default
+	RotationSRSPlus = "srs+" // SRS with symmetric I kicks and 180° kicks (TETR.IO)
+	RotationARS     = "ars"  // Arika Rotation System (TGM)
+	RotationNRS     = "nrs"  // Nintendo Rotation System (NES)
+)
+
+// RotationSystems lists the rotation system names in menu order
+var RotationSystems = []string{RotationSRS, RotationSRSPlus, RotationARS, RotationNRS}
+
+// rotationSystems maps rotation system names to implementations
+var rotationSystems = map[string]RotationSystem{
+	RotationSRS:     srsRotation{},
+	RotationSRSPlus: srsPlusRotation{},
+	RotationARS:     arsRotation{},
+	RotationNRS:     nrsRotation{},
+}
+
+// rotationSystem returns the named rotation system, defaulting to SRS
+func rotationSystem(name string) RotationSystem {
+	if name == "" {
+		// Most pieces, skipping a lookup made for every collision test
+		return srsRotation{}
+	}
+	if rs, ok := rotationSystems[name]; ok {
+		return rs
+	}
+	return srsRotation{}
+}
+
+// rotationName returns the display name of a rotation system
+func rotationName(name string) string {
+	switch name {
+	case RotationSRSPlus:
+		return "SRS+"
+	case RotationARS:
+		return "ARS"
+	case RotationNRS:
+		return "NRS"
+	}
+	return "SRS"
+}
+
+// supports180 reports whether the named rotation system has 180° kicks
+func supports180(name string) bool {
+	p := NewSpawnPiece(PieceT, name)
+	return len(rotationSystem(name).Kicks(NewBoard(), p, quarterTurns(p.Rotation, 2))) > 0
+}
+
+// quarterTurns turns a state through all four states
+func quarterTurns(r RotationState, dir int) RotationState {
+	return RotationState(((int(r)+dir)%4 + 4) % 4)
+}
+
+// twoStateTurns flips a two-state piece between 0 and R; a 180° turn
+// leaves it where it is
+func twoStateTurns(r RotationState, dir int) RotationState {
+	if dir%2 == 0 {
+		return r
+	}
+	if r == Rotation0 {
+		return RotationR
+	}
+	return Rotation0
+}
+
+// srsRotation is the guideline rotation system: four states for every
+// piece and the standard wall kick tables, with no 180° rotation
+type srsRotation struct{}
+
+// Shape returns the SRS cells from pieceShapes
+func (srsRotation) Shape(t PieceType, r RotationState) []Offset {
+	return pieceShapes[t][r][:]
+}
+
+// Spawn places pieces in the middle of the top two rows, with the I
+// piece's 4×4 box starting a row above the board
+func (srsRotation) Spawn(t PieceType) (row, col int) {
+	switch t {
+	case PieceI:
+		return -1, 3
+	case PieceO:
+		return 0, 4
+	}
+	return 0, 3
+}
+
+// Target turns the piece through all four states
+func (srsRotation) Target(p *Piece, dir int) RotationState {
+	return quarterTurns(p.Rotation, dir)
+}
+
+// Kicks returns the SRS wall kick tests from GetWallKicks, or none
+// for a 180° turn
+func (srsRotation) Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset {
+	if quarterTurns(p.Rotation, 2) == target {
+		return nil
+	}
+	kicks := p.GetWallKicks(target)
+	return kicks[:]
+}
+
+// srsPlusRotation is SRS as played in TETR.IO: the I piece's kicks are
+// mirrored so both directions behave alike, and pieces turn 180°
+type srsPlusRotation struct {
+	srsRotation
+}
+
+// Kicks returns the 180° tests from wallKicks180 (the O piece turns in
+// place), the SRS+ I kicks for quarter turns of the I piece, and the
+// SRS tests otherwise
+func (rs srsPlusRotation) Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset {
+	switch {
+	case quarterTurns(p.Rotation, 2) == target && p.Type == PieceO:
+		kicks := p.GetWallKicks(target)
+		return kicks[:]
+	case quarterTurns(p.Rotation, 2) == target:
+		kicks := wallKicks180[p.Rotation][target]
+		return kicks[:]
+	case p.Type == PieceI:
+		kicks := wallKicksSRSPlusI[p.Rotation][target]
+		return kicks[:]
+	}
+	return rs.srsRotation.Kicks(b, p, target)
+}
+
+// arsRotation is the TGM rotation system: pieces sit flat side down in
+// their box and spawn pointing down, I, S and Z have two states, and a
+// piece that doesn't fit tries one column right, then one column left
+type arsRotation struct{}
+
+// Shape returns the ARS cells from arsShapes
+func (arsRotation) Shape(t PieceType, r RotationState) []Offset {
+	return arsShapes[t][r][:]
+}
+
+// Spawn places every piece so its box's second row is the top row
+func (arsRotation) Spawn(t PieceType) (row, col int) {
+	if t == PieceO {
+		return -1, 4
+	}
+	return -1, 3
+}
+
+// Target flips I, S and Z between two states and turns the others
+// through all four
+func (arsRotation) Target(p *Piece, dir int) RotationState {
+	switch p.Type {
+	case PieceI, PieceS, PieceZ:
+		return twoStateTurns(p.Rotation, dir)
+	}
+	return quarterTurns(p.Rotation, dir)
+}
+
+// Kicks tries the unshifted position, then right and left by one
+// I and O never kick, and ARS has no 180° rotation. L, J and T don't
+// kick when the first blocked cell of the turned piece, in reading
+// order, is in the middle column of its box: that's a piece turning
+// around a block it is standing on or hanging from
+func (arsRotation) Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset {
+	if target == p.Rotation || quarterTurns(p.Rotation, 2) == target {
+		return nil
+	}
+	stay := []WallKickOffset{{Row: 0, Col: 0}}
+	switch p.Type {
+	case PieceI, PieceO:
+		return stay
+	case PieceL, PieceJ, PieceT:
+	scan:
+		for _, cell := range arsShapes[p.Type][target] {
+			for _, block := range p.mino(cell) {
+				if b.blocked(block.Row, block.Col) {
+					if cell.Col == 1 {
+						return stay
+					}
+					break scan
+				}
+			}
+		}
+	}
+	return append(stay, WallKickOffset{Row: 0, Col: 1}, WallKickOffset{Row: 0, Col: -1})
+}
+
+// nrsRotation is the NES rotation system: no wall kicks at all, pieces
+// turn around a fixed center block, and I, S and Z only have two
+// states, flipping between spawn and the right-handed vertical state
+type nrsRotation struct{}
+
+// Shape returns the NRS cells from nrsShapes
+func (nrsRotation) Shape(t PieceType, r RotationState) []Offset {
+	return nrsShapes[t][r][:]
+}
+
+// Spawn places every piece so its center block is on the top row
+func (nrsRotation) Spawn(t PieceType) (row, col int) {
+	switch t {
+	case PieceI:
+		return -2, 3
+	case PieceO:
+		return -1, 4
+	}
+	return -1, 3
+}
+
+// Target flips two-state pieces between 0 and R, and turns the others
+// through all four states
+func (nrsRotation) Target(p *Piece, dir int) RotationState {
+	switch p.Type {
+	case PieceI, PieceS, PieceZ:
+		return twoStateTurns(p.Rotation, dir)
+	}
+	return quarterTurns(p.Rotation, dir)
+}
+
+// Kicks only tries the unshifted position, and NRS has no 180° rotation
+func (nrsRotation) Kicks(b *Board, p *Piece, target RotationState) []WallKickOffset {
+	if target == p.Rotation || quarterTurns(p.Rotation, 2) == target {
+		return nil
+	}
+	return []WallKickOffset{{Row: 0, Col: 0}}
+}
+
+// classicO is the O piece in the classic systems, in the lower rows
+// of its box like the other pieces
+var classicO = [4]Offset{{1, 0}, {1, 1}, {2, 0}, {2, 1}}
+
+// arsShapes are the TGM piece states in 3×3 boxes (4×4 for I), with
+// every state resting on the bottom of the box. Two-state pieces
+// repeat their states for 2 and L
+var arsShapes = [PieceL + 1][4][4]Offset{
+	PieceI: {
+		{{1, 0}, {1, 1}, {1, 2}, {1, 3}},
+		{{0, 2}, {1, 2}, {2, 2}, {3, 2}},
+		{{1, 0}, {1, 1}, {1, 2}, {1, 3}},
+		{{0, 2}, {1, 2}, {2, 2}, {3, 2}},
+	},
+	PieceO: {classicO, classicO, classicO, classicO},
+	PieceT: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 1}},
+		{{0, 1}, {1, 0}, {1, 1}, {2, 1}},
+		{{1, 1}, {2, 0}, {2, 1}, {2, 2}},
+		{{0, 1}, {1, 1}, {1, 2}, {2, 1}},
+	},
+	PieceS: {
+		{{1, 1}, {1, 2}, {2, 0}, {2, 1}},
+		{{0, 0}, {1, 0}, {1, 1}, {2, 1}},
+		{{1, 1}, {1, 2}, {2, 0}, {2, 1}},
+		{{0, 0}, {1, 0}, {1, 1}, {2, 1}},
+	},
+	PieceZ: {
+		{{1, 0}, {1, 1}, {2, 1}, {2, 2}},
+		{{0, 2}, {1, 1}, {1, 2}, {2, 1}},
+		{{1, 0}, {1, 1}, {2, 1}, {2, 2}},
+		{{0, 2}, {1, 1}, {1, 2}, {2, 1}},
+	},
+	PieceJ: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 2}},
+		{{0, 1}, {1, 1}, {2, 0}, {2, 1}},
+		{{1, 0}, {2, 0}, {2, 1}, {2, 2}},
+		{{0, 1}, {0, 2}, {1, 1}, {2, 1}},
+	},
+	PieceL: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 0}},
+		{{0, 0}, {0, 1}, {1, 1}, {2, 1}},
+		{{1, 2}, {2, 0}, {2, 1}, {2, 2}},
+		{{0, 1}, {1, 1}, {2, 1}, {2, 2}},
+	},
+}
+
+// nrsShapes are the NES piece states: L, J and T turn around the
+// middle of their box and spawn pointing down, and S, Z and I flip to
+// the vertical state on the right of their center block
+var nrsShapes = [PieceL + 1][4][4]Offset{
+	PieceI: {
+		{{2, 0}, {2, 1}, {2, 2}, {2, 3}},
+		{{0, 2}, {1, 2}, {2, 2}, {3, 2}},
+		{{2, 0}, {2, 1}, {2, 2}, {2, 3}},
+		{{0, 2}, {1, 2}, {2, 2}, {3, 2}},
+	},
+	PieceO: {classicO, classicO, classicO, classicO},
+	PieceT: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 1}},
+		{{0, 1}, {1, 0}, {1, 1}, {2, 1}},
+		{{0, 1}, {1, 0}, {1, 1}, {1, 2}},
+		{{0, 1}, {1, 1}, {1, 2}, {2, 1}},
+	},
+	PieceS: {
+		{{1, 1}, {1, 2}, {2, 0}, {2, 1}},
+		{{0, 1}, {1, 1}, {1, 2}, {2, 2}},
+		{{1, 1}, {1, 2}, {2, 0}, {2, 1}},
+		{{0, 1}, {1, 1}, {1, 2}, {2, 2}},
+	},
+	PieceZ: {
+		{{1, 0}, {1, 1}, {2, 1}, {2, 2}},
+		{{0, 2}, {1, 1}, {1, 2}, {2, 1}},
+		{{1, 0}, {1, 1}, {2, 1}, {2, 2}},
+		{{0, 2}, {1, 1}, {1, 2}, {2, 1}},
+	},
+	PieceJ: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 2}},
+		{{0, 1}, {1, 1}, {2, 0}, {2, 1}},
+		{{0, 0}, {1, 0}, {1, 1}, {1, 2}},
+		{{0, 1}, {0, 2}, {1, 1}, {2, 1}},
+	},
+	PieceL: {
+		{{1, 0}, {1, 1}, {1, 2}, {2, 0}},
+		{{0, 0}, {0, 1}, {1, 1}, {2, 1}},
+		{{0, 2}, {1, 0}, {1, 1}, {1, 2}},
+		{{0, 1}, {1, 1}, {2, 1}, {2, 2}},
+	},
+}
+
+// SRS+ wall kicks for the I piece, mirrored left to right so turning
+// clockwise and counter-clockwise kick alike
+var wallKicksSRSPlusI = map[RotationState]map[RotationState][5]WallKickOffset{
+	Rotation0: {
+		RotationR: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: 1},
+			{Row: 0, Col: -2},
+			{Row: 1, Col: -2},
+			{Row: -2, Col: 1},
+		},
+		RotationL: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: -1},
+			{Row: 0, Col: 2},
+			{Row: 1, Col: 2},
+			{Row: -2, Col: -1},
+		},
+	},
+	RotationR: {
+		Rotation0: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: -1},
+			{Row: 0, Col: 2},
+			{Row: 2, Col: -1},
+			{Row: -1, Col: 2},
+		},
+		Rotation2: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: -1},
+			{Row: 0, Col: 2},
+			{Row: -2, Col: -1},
+			{Row: 1, Col: 2},
+		},
+	},
+	Rotation2: {
+		RotationR: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: -2},
+			{Row: 0, Col: 1},
+			{Row: -1, Col: -2},
+			{Row: 2, Col: 1},
+		},
+		RotationL: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: 2},
+			{Row: 0, Col: -1},
+			{Row: -1, Col: 2},
+			{Row: 2, Col: -1},
+		},
+	},
+	RotationL: {
+		Rotation0: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: 1},
+			{Row: 0, Col: -2},
+			{Row: 2, Col: 1},
+			{Row: -1, Col: -2},
+		},
+		Rotation2: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: 1},
+			{Row: 0, Col: -2},
+			{Row: -2, Col: 1},
+			{Row: 1, Col: -2},
+		},
+	},
+}
+
+// 180° kicks from TETR.IO's SRS+, used for every piece except O
+var wallKicks180 = map[RotationState]map[RotationState][6]WallKickOffset{
+	Rotation0: {
+		Rotation2: {
+			{Row: 0, Col: 0},
+			{Row: -1, Col: 0},
+			{Row: -1, Col: 1},
+			{Row: -1, Col: -1},
+			{Row: 0, Col: 1},
+			{Row: 0, Col: -1},
+		},
+	},
+	RotationR: {
+		RotationL: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: 1},
+			{Row: -2, Col: 1},
+			{Row: -1, Col: 1},
+			{Row: -2, Col: 0},
+			{Row: -1, Col: 0},
+		},
+	},
+	Rotation2: {
+		Rotation0: {
+			{Row: 0, Col: 0},
+			{Row: 1, Col: 0},
+			{Row: 1, Col: -1},
+			{Row: 1, Col: 1},
+			{Row: 0, Col: -1},
+			{Row: 0, Col: 1},
+		},
+	},
+	RotationL: {
+		RotationR: {
+			{Row: 0, Col: 0},
+			{Row: 0, Col: -1},
+			{Row: -2, Col: -1},
+			{Row: -1, Col: -1},
+			{Row: -2, Col: 0},
+			{Row: -1, Col: 0},
+		},
+	},
+}
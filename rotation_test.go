@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSRSPlusIKicksMirrored(t *testing.T) {
+	mirror := map[RotationState]RotationState{
+		Rotation0: Rotation0,
+		RotationR: RotationL,
+		Rotation2: Rotation2,
+		RotationL: RotationR,
+	}
+	for from, tos := range wallKicksSRSPlusI {
+		for to, kicks := range tos {
+			mirrored := wallKicksSRSPlusI[mirror[from]][mirror[to]]
+			for i, kick := range kicks {
+				if want := (WallKickOffset{Row: kick.Row, Col: -kick.Col}); mirrored[i] != want {
+					t.Errorf("%v->%v test %d is %+v, want %v->%v's mirrored, %+v",
+						mirror[from], mirror[to], i+1, mirrored[i], from, to, want)
+				}
+			}
+		}
+	}
+}
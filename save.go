@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// SaveVersion is the version of the save file format. Saves from any
+// other version are refused rather than resumed wrongly
+const SaveVersion = 1
+
+// Errors returned by LoadGame for saves that can't be resumed
+var (
+	ErrSaveVersion = errors.New("save is from an incompatible version")
+	ErrSaveCorrupt = errors.New("save file is corrupted")
+	ErrSaveInvalid = errors.New("save is not a valid game")
+)
+
+// saveFile is the on-disk envelope: the saved game and a checksum of
+// its exact bytes, so a truncated or damaged file is detected
+type saveFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// savedGame is everything needed to resume a Game: its exported state,
+// the random generators so it deals the same pieces and garbage, and
+// the engine's timers
+type savedGame struct {
+	Game       *Game           `json:"game"`
+	Randomizer json.RawMessage `json:"randomizer"`
+	Garbage    json.RawMessage `json:"garbage,omitempty"`
+	State      savedState      `json:"state"`
+	Replay     *Replay         `json:"replay,omitempty"` // Recording so far, so the resumed game's replay is whole
+}
+
+// savedState is the unexported engine state of a Game, including a
+// shift still held and a buffered initial rotation or hold, so a game
+// saved mid-input resumes as the replay recorded it
+type savedState struct {
+	GarbageAdded int  `json:"garbage_added"`
+	RiseTimer    int  `json:"rise_timer"`
+	RiseInterval int  `json:"rise_interval"`
+	GravityAcc   int  `json:"gravity_acc"`
+	LockTimer    int  `json:"lock_timer"`
+	LockResets   int  `json:"lock_resets"`
+	LowestRow    int  `json:"lowest_row"`
+	PhaseTimer   int  `json:"phase_timer"`
+	FlashTimer   int  `json:"flash_timer"`
+	Combo        int  `json:"combo"`
+	SoftRows     int  `json:"soft_rows"`
+	GMEligible   bool `json:"gm_eligible"`
+	Rotated      bool `json:"rotated"`
+	LastKick     int  `json:"last_kick"`
+	Keys         int  `json:"keys"`
+
+	HeldShift       int  `json:"held_shift"`
+	DASTimer        int  `json:"das_timer"`
+	InitialRotation int  `json:"initial_rotation"`
+	InitialHold     bool `json:"initial_hold"`
+}
+
+// SaveGame writes the game to path, creating its directory if needed
+func SaveGame(path string, g *Game) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := encodeGame(g)
+	if err != nil {
+		return err
+	}
+
+	file, err := json.MarshalIndent(saveFile{
+		Version:  SaveVersion,
+		Checksum: saveChecksum(data),
+		Data:     data,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, file, 0o644)
+}
+
+// encodeGame returns the complete state of a game as JSON
+func encodeGame(g *Game) ([]byte, error) {
+	saved := savedGame{
+		Game:   g,
+		Replay: g.recording,
+		State: savedState{
+			GarbageAdded: g.garbageAdded,
+			RiseTimer:    g.riseTimer,
+			RiseInterval: g.riseInterval,
+			GravityAcc:   g.gravityAcc,
+			LockTimer:    g.lockTimer,
+			LockResets:   g.lockResets,
+			LowestRow:    g.lowestRow,
+			PhaseTimer:   g.phaseTimer,
+			FlashTimer:   g.flashTimer,
+			Combo:        g.master.combo,
+			SoftRows:     g.master.softRows,
+			GMEligible:   g.master.gmEligible,
+			Rotated:      g.rotated,
+			LastKick:     g.lastKick,
+			Keys:         g.keys,
+
+			HeldShift:       g.heldShift,
+			DASTimer:        g.dasTimer,
+			InitialRotation: g.initialRotation,
+			InitialHold:     g.initialHold,
+		},
+	}
+	var err error
+	if saved.Randomizer, err = json.Marshal(g.randomizer); err != nil {
+		return nil, err
+	}
+	if g.garbage != nil {
+		if saved.Garbage, err = json.Marshal(g.garbage); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(saved)
+}
+
+// LoadGame reads a game saved with SaveGame
+// Saves from another version, damaged saves and saves that don't
+// describe a playable game are refused with ErrSaveVersion,
+// ErrSaveCorrupt or ErrSaveInvalid
+func LoadGame(path string) (*Game, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file saveFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSaveInvalid, err)
+	}
+	if file.Version != SaveVersion {
+		return nil, fmt.Errorf("%w: version %d, expected %d", ErrSaveVersion, file.Version, SaveVersion)
+	}
+	// The file is indented, so the data is compacted back to the bytes
+	// the checksum was taken of
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file.Data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSaveInvalid, err)
+	}
+	if file.Checksum != saveChecksum(compact.Bytes()) {
+		return nil, ErrSaveCorrupt
+	}
+	g, err := decodeGame(file.Data)
+	if err != nil {
+		return nil, err
+	}
+	if g.Mode.Undo {
+		// Undo history isn't saved: it starts afresh from here
+		g.history = newHistory(g)
+	}
+	return g, nil
+}
+
+// decodeGame restores a game encoded with encodeGame, refusing it with
+// ErrSaveInvalid if it isn't a playable game
+func decodeGame(data []byte) (*Game, error) {
+	saved := savedGame{Game: &Game{}}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSaveInvalid, err)
+	}
+	g := saved.Game
+	if err := validateSave(g); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSaveInvalid, err)
+	}
+
+	g.randomizer = newRandomizer(g.Mode.Randomizer, 0, pieceSet(g.Mode.Pieces))
+	if err := g.randomizer.UnmarshalJSON(saved.Randomizer); err != nil {
+		return nil, fmt.Errorf("%w: randomizer: %v", ErrSaveInvalid, err)
+	}
+	if g.Mode.GarbageGoal > 0 || g.Mode.RiseInterval > 0 {
+		g.garbage = &GarbageGenerator{}
+		if err := g.garbage.UnmarshalJSON(saved.Garbage); err != nil {
+			return nil, fmt.Errorf("%w: garbage: %v", ErrSaveInvalid, err)
+		}
+	}
+
+	state := saved.State
+	if state.HeldShift < -1 || state.HeldShift > 1 || state.DASTimer < 0 ||
+		state.InitialRotation < -1 || state.InitialRotation > 2 {
+		return nil, fmt.Errorf("%w: held input", ErrSaveInvalid)
+	}
+	g.garbageAdded = state.GarbageAdded
+	g.riseTimer = state.RiseTimer
+	g.riseInterval = state.RiseInterval
+	g.gravityAcc = state.GravityAcc
+	g.lockTimer = state.LockTimer
+	g.lockResets = state.LockResets
+	g.lowestRow = state.LowestRow
+	g.phaseTimer = state.PhaseTimer
+	g.flashTimer = state.FlashTimer
+	g.rotated = state.Rotated
+	g.lastKick = state.LastKick
+	g.keys = state.Keys
+	g.heldShift = state.HeldShift
+	g.dasTimer = state.DASTimer
+	g.initialRotation = state.InitialRotation
+	g.initialHold = state.InitialHold
+	g.master = masterState{
+		combo:      state.Combo,
+		softRows:   state.SoftRows,
+		gmEligible: state.GMEligible,
+	}
+	if saved.Replay != nil {
+		if err := saved.Replay.resume(); err != nil {
+			return nil, fmt.Errorf("%w: replay: %v", ErrSaveInvalid, err)
+		}
+		g.recording = saved.Replay
+	}
+	return g, nil
+}
+
+// validatePiece checks that a saved piece belongs to the game: it's in
+// the piece set and a state of the mode's rotation system, and fits on
+// the board
+func validatePiece(p *Piece, set []PieceType, g *Game) error {
+	if !slices.Contains(set, p.Type) || p.Rotation < Rotation0 || p.Rotation > RotationL {
+		return errors.New("piece is not valid")
+	}
+	if p.System != g.Mode.Rotation || p.Big != g.Mode.Big {
+		return errors.New("piece doesn't match the mode")
+	}
+	if g.Board.Collides(p) {
+		return errors.New("piece overlaps the stack")
+	}
+	return nil
+}
+
+// validateOpener checks a saved opener's steps, and that the step the
+// game is on and the placements left in it are ones the opener has
+func validateOpener(g *Game, set []PieceType) error {
+	steps := g.Mode.Opener.Steps
+	if len(steps) == 0 {
+		return errors.New("opener has no steps")
+	}
+	for i, step := range steps {
+		if step.Board == nil {
+			return fmt.Errorf("opener step %d has no board", i+1)
+		}
+		if step.HasHold && !slices.Contains(set, step.Hold) {
+			return fmt.Errorf("opener step %d holds a piece not in the piece set", i+1)
+		}
+	}
+	if g.OpenerStep < 0 || g.OpenerStep > len(steps) || (g.OpenerStep == 0 && len(g.OpenerLeft) > 0) {
+		return fmt.Errorf("opener is at step %d of %d", g.OpenerStep, len(steps))
+	}
+	placed := map[PieceType]bool{}
+	for _, p := range g.OpenerLeft {
+		if err := validatePiece(&p, set, g); err != nil {
+			return fmt.Errorf("opener placement: %w", err)
+		}
+		// Each piece is placed at most once in a step, in cells of its
+		// color on the step's board
+		if placed[p.Type] || !slices.ContainsFunc(steps[g.OpenerStep-1].Board.Cells[:], func(row [BoardWidth]Cell) bool {
+			return slices.Contains(row[:], NewFilledCell(p.Color()))
+		}) {
+			return fmt.Errorf("opener placement of %s is not in step %d", p.Type, g.OpenerStep)
+		}
+		placed[p.Type] = true
+	}
+	return nil
+}
+
+// saveChecksum returns the hex SHA-256 of a save's data
+// It is unkeyed, so it catches corruption but not deliberate edits:
+// anyone can recompute it, and validateSave is what keeps an edited
+// save from breaking the game
+func saveChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validateSave checks that a loaded game can be played: its pieces
+// belong to its piece set, which must be loaded, and the piece in play
+// matches its phase and fits on the board
+func validateSave(g *Game) error {
+	if g.Board == nil {
+		return errors.New("no board")
+	}
+	if g.Over() {
+		return errors.New("game is already over")
+	}
+	if _, ok := rotationSystems[g.Mode.Rotation]; !ok && g.Mode.Rotation != "" {
+		return fmt.Errorf("unknown rotation system %q", g.Mode.Rotation)
+	}
+	set, ok := pieceSets[g.Mode.Pieces]
+	if !ok {
+		return fmt.Errorf("piece set %q is not loaded", g.Mode.Pieces)
+	}
+	if len(g.Queue) > g.Mode.previewCount() || (len(g.Queue) < g.Mode.previewCount() && !g.Mode.NoRandom) {
+		return fmt.Errorf("queue has %d pieces, expected %d", len(g.Queue), g.Mode.previewCount())
+	}
+	for _, t := range slices.Concat(g.Queue, g.Fixed) {
+		if !slices.Contains(set, t) {
+			return fmt.Errorf("queued piece %d is not in the piece set", t)
+		}
+	}
+	if g.HasHold && !slices.Contains(set, g.Hold) {
+		return fmt.Errorf("held piece %d is not in the piece set", g.Hold)
+	}
+
+	if g.Target != nil {
+		if err := validatePiece(g.Target, set, g); err != nil {
+			return fmt.Errorf("target: %w", err)
+		}
+	}
+	if g.Mode.Opener != nil {
+		if err := validateOpener(g, set); err != nil {
+			return err
+		}
+	}
+
+	switch g.Phase {
+	case PhaseFalling:
+		if g.Current == nil {
+			return errors.New("no piece in play")
+		}
+		if err := validatePiece(g.Current, set, g); err != nil {
+			return fmt.Errorf("piece in play: %w", err)
+		}
+	case PhaseLineClear, PhaseEntry:
+		if g.Current != nil {
+			return errors.New("piece in play between pieces")
+		}
+	default:
+		return fmt.Errorf("unknown phase %d", g.Phase)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// savePosition is the stack the save tests start from
+const savePosition = `
+hold: T
+queue: IOSZ
+________#_
+##_#######
+`
+
+// savedTestGame returns a game from savePosition after a few pieces
+func savedTestGame(t *testing.T) *Game {
+	t.Helper()
+	pos, err := ParsePosition(savePosition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGameAt(NewMarathonMode(1), 7, pos)
+	for range 3 {
+		g.Apply(ActionHardDrop)
+		for g.Phase != PhaseFalling && !g.Over() {
+			g.Step()
+		}
+	}
+	return g
+}
+
+// writeSave saves g to a temporary file and returns its path
+func writeSave(t *testing.T, g *Game) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "save.json")
+	if err := SaveGame(path, g); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// editSave rewrites the envelope of the save at path
+func editSave(t *testing.T, path string, edit func(*saveFile)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file saveFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	edit(&file)
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveRoundTrip(t *testing.T) {
+	g := savedTestGame(t)
+	loaded, err := LoadGame(writeSave(t, g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := FormatBoard(loaded.Board), FormatBoard(g.Board); got != want {
+		t.Errorf("board:\n%s\nwant:\n%s", got, want)
+	}
+	if loaded.StateHash() != g.StateHash() {
+		t.Error("loaded game's state differs from the saved one")
+	}
+}
+
+func TestLoadGameRejectsVersion(t *testing.T) {
+	path := writeSave(t, savedTestGame(t))
+	editSave(t, path, func(f *saveFile) { f.Version = SaveVersion + 1 })
+	if _, err := LoadGame(path); !errors.Is(err, ErrSaveVersion) {
+		t.Errorf("got %v, want ErrSaveVersion", err)
+	}
+}
+
+func TestLoadGameRejectsChecksum(t *testing.T) {
+	path := writeSave(t, savedTestGame(t))
+	editSave(t, path, func(f *saveFile) {
+		// Lines go from 0 to 40 without the checksum being updated
+		edited := strings.Replace(string(f.Data), `"Lines": 0`, `"Lines": 40`, 1)
+		if edited == string(f.Data) {
+			t.Fatal("save has no line count to edit")
+		}
+		f.Data = json.RawMessage(edited)
+	})
+	if _, err := LoadGame(path); !errors.Is(err, ErrSaveCorrupt) {
+		t.Errorf("got %v, want ErrSaveCorrupt", err)
+	}
+}
+
+// openerTestGame returns a game of the first built-in opener, on its
+// first step
+func openerTestGame(t *testing.T) *Game {
+	t.Helper()
+	openers, err := StarterOpeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode := NewOpenerMode(openers[0])
+	queue, err := mode.Opener.Queue(mode, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGameAt(mode, 1, Position{Queue: queue})
+}
+
+func TestValidateSaveRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		game func(t *testing.T) *Game
+		edit func(g *Game)
+	}{
+		{"no board", savedTestGame, func(g *Game) { g.Board = nil }},
+		{"game over", savedTestGame, func(g *Game) { g.Result = ResultFailed }},
+		{"unknown rotation system", savedTestGame, func(g *Game) { g.Mode.Rotation = "XRS" }},
+		{"unknown piece set", savedTestGame, func(g *Game) { g.Mode.Pieces = "hexominoes" }},
+		{"short queue", savedTestGame, func(g *Game) { g.Queue = g.Queue[:1] }},
+		{"piece outside the set", savedTestGame, func(g *Game) { g.Queue[0] = PieceType(99) }},
+		{"held piece outside the set", savedTestGame, func(g *Game) { g.Hold, g.HasHold = PieceType(99), true }},
+		{"rotation out of range", savedTestGame, func(g *Game) { g.Current.Rotation = RotationL + 1 }},
+		{"piece in the stack", savedTestGame, func(g *Game) { g.Current.Row = BoardHeight - 1 }},
+		{"no piece in play", savedTestGame, func(g *Game) { g.Current = nil }},
+		{"piece between pieces", savedTestGame, func(g *Game) { g.Phase = PhaseEntry }},
+		{"unknown phase", savedTestGame, func(g *Game) { g.Phase = Phase(99) }},
+		{"bag piece outside the set", savedTestGame, func(g *Game) {
+			bag := g.randomizer.(*Bag)
+			bag.pieces = append(bag.pieces, PieceType(99))
+		}},
+		{"NES piece outside the set", func(t *testing.T) *Game { return NewGame(NewClassicMode(0), 1) }, func(g *Game) {
+			g.randomizer.(*NESRandomizer).prev = PieceType(99)
+		}},
+		{"opener step past the last", openerTestGame, func(g *Game) { g.OpenerStep = len(g.Mode.Opener.Steps) + 1 }},
+		{"opener placements before the first step", openerTestGame, func(g *Game) { g.OpenerStep = 0 }},
+		{"opener placement outside the set", openerTestGame, func(g *Game) { g.OpenerLeft[0].Type = PieceType(99) }},
+		{"opener placement not in the step", openerTestGame, func(g *Game) {
+			g.OpenerLeft = append(g.OpenerLeft, g.OpenerLeft[0])
+		}},
+		{"opener step without a board", openerTestGame, func(g *Game) {
+			o := *g.Mode.Opener
+			o.Steps = slices.Clone(o.Steps)
+			o.Steps[0].Board = nil
+			g.Mode.Opener = &o
+		}},
+		{"target outside the set", openerTestGame, func(g *Game) {
+			target := *g.Current
+			target.Type = PieceType(99)
+			g.Target = &target
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.game(t)
+			data, err := encodeGame(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decodeGame(data); err != nil {
+				t.Fatalf("unedited game refused: %v", err)
+			}
+			tt.edit(g)
+			if data, err = encodeGame(g); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decodeGame(data); !errors.Is(err, ErrSaveInvalid) {
+				t.Errorf("got %v, want ErrSaveInvalid", err)
+			}
+		})
+	}
+}
+
+func TestSaveResumesHeldInput(t *testing.T) {
+	pos, err := ParsePosition(replayStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const seed = 7
+	g := NewGameAt(NewMasterMode(), seed, pos)
+	g.StartRecording(seed, pos)
+	resume := func() {
+		t.Helper()
+		loaded, err := LoadGame(writeSave(t, g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g = loaded
+	}
+
+	// Saved halfway to auto-shift, which starts after the resume
+	g.Press(ActionLeft)
+	for range g.Mode.DAS / 2 {
+		g.Step()
+	}
+	resume()
+	if g.HeldShift() != -1 {
+		t.Fatal("shift isn't held after resuming")
+	}
+	for range g.Mode.DAS {
+		g.Step()
+	}
+	g.Release(ActionLeft)
+
+	// Saved with a rotation buffered during entry delay
+	g.Apply(ActionHardDrop)
+	if g.Phase == PhaseFalling {
+		t.Fatal("no entry delay to buffer a rotation in")
+	}
+	g.Apply(ActionRotateCW)
+	resume()
+
+	for !g.Over() {
+		g.Apply(ActionHardDrop)
+		for range FramesPerSecond / 4 {
+			g.Step()
+		}
+	}
+	if err := g.Recording().Verify(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+// Spin is the kind of T-spin a locked piece made
+type Spin int
+
+const (
+	SpinNone Spin = iota
+	SpinMini      // Three corners blocked, but not both in front of the T
+	SpinFull      // Three corners blocked, including both in front of the T
+)
+
+// tstKick is the index of SRS's last kick, the one T-spin triples
+// rely on, which makes a mini into a full T-spin
+const tstKick = 4
+
+// String returns the name of a spin
+func (s Spin) String() string {
+	switch s {
+	case SpinMini:
+		return "T-Spin Mini"
+	case SpinFull:
+		return "T-Spin"
+	}
+	return ""
+}
+
+// tSpin returns the spin the current piece makes by locking where it
+// is, see spinOf
+func (g *Game) tSpin() Spin {
+	return spinOf(g.Board, g.Current, g.rotated, g.lastKick)
+}
+
+// spinOf returns the spin a piece makes by locking where it is on the
+// board: a T whose last move was a rotation, with at least three of the
+// four cells diagonal to its center blocked by the stack or walls
+// It is a full T-spin when both corners on the side the T points to
+// are blocked or the rotation needed the TST kick, otherwise a mini
+func spinOf(b *Board, p *Piece, rotated bool, kick int) Spin {
+	if p.Type != PieceT || p.Big || !rotated {
+		return SpinNone
+	}
+	center, nose, ok := tShape(p.Cells())
+	if !ok {
+		return SpinNone
+	}
+
+	corners, front := 0, 0
+	for _, dRow := range []int{-1, 1} {
+		for _, dCol := range []int{-1, 1} {
+			if !b.blocked(center.Row+dRow, center.Col+dCol) {
+				continue
+			}
+			corners++
+			if dRow == nose.Row || dCol == nose.Col {
+				front++
+			}
+		}
+	}
+	switch {
+	case corners < 3:
+		return SpinNone
+	case front == 2 || kick == tstKick:
+		return SpinFull
+	}
+	return SpinMini
+}
+
+// tShape finds the center of a T's cells, the one touching the other
+// three, and the direction its nose points in from there
+// Works for any rotation system's T
+func tShape(cells []Offset) (center, nose Offset, ok bool) {
+	has := func(row, col int) bool {
+		for _, cell := range cells {
+			if cell.Row == row && cell.Col == col {
+				return true
+			}
+		}
+		return false
+	}
+	directions := []Offset{{Row: -1}, {Row: 1}, {Col: -1}, {Col: 1}}
+	for _, cell := range cells {
+		neighbours := 0
+		for _, d := range directions {
+			if has(cell.Row+d.Row, cell.Col+d.Col) {
+				neighbours++
+			}
+		}
+		if neighbours != 3 {
+			continue
+		}
+		// The nose is the neighbour with no cell opposite it
+		for _, d := range directions {
+			if !has(cell.Row-d.Row, cell.Col-d.Col) {
+				return cell, d, true
+			}
+		}
+	}
+	return Offset{}, Offset{}, false
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// The Tetris Bot Protocol (TBP) lets a game and a bot run as separate
+// processes: the game starts the bot and they swap JSON messages, one
+// per line, over the bot's stdin and stdout. The bot introduces itself
+// with info, is given the rules and answers ready, then is sent the
+// game's state with start. Each suggest is answered with a suggestion
+// of moves, best first; the game tells the bot the move it made with
+// play, and new preview pieces with new_piece. Coordinates have x from
+// the left and y from the bottom of a 40-row board, and a piece's
+// location is its center of rotation, which is fumen's origin too
+
+// tbpRows is the height of a TBP board
+const tbpRows = 40
+
+// tbpTimeout is how long a bot has to answer a message
+const tbpTimeout = 10 * time.Second
+
+// tbpOrientations are TBP's names for the rotation states
+var tbpOrientations = [...]string{Rotation0: "north", RotationR: "east", Rotation2: "south", RotationL: "west"}
+
+// tbpSpins are TBP's names for the spins
+var tbpSpins = [...]string{SpinNone: "none", SpinMini: "mini", SpinFull: "full"}
+
+// tbpMessage is a TBP message of any type. A type's fields are grouped
+// in an embedded struct, left nil when the message doesn't have them
+type tbpMessage struct {
+	Type string `json:"type"`
+	*TBPInfo
+	*TBPStart
+	*TBPSuggestion
+	Reason string   `json:"reason,omitempty"` // Why a bot sent an error
+	Piece  string   `json:"piece,omitempty"`  // Piece added to the queue by new_piece
+	Move   *TBPMove `json:"move,omitempty"`   // Move made, for play
+}
+
+// TBPInfo is how a bot introduces itself
+type TBPInfo struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Author   string   `json:"author"`
+	Features []string `json:"features"`
+}
+
+// TBPStart is the game state a bot is given to start from. Queue starts
+// with the piece in play
+type TBPStart struct {
+	Hold       *string     `json:"hold"`
+	Queue      []string    `json:"queue"`
+	Combo      int         `json:"combo"`
+	BackToBack bool        `json:"back_to_back"`
+	Board      [][]*string `json:"board"` // tbpRows rows from the bottom up
+}
+
+// TBPSuggestion is a bot's answer to suggest, best move first
+type TBPSuggestion struct {
+	Moves []TBPMove `json:"moves"`
+}
+
+// TBPMove is where a piece locks. Holding is implied by the piece
+// being another than the one in play
+type TBPMove struct {
+	Location TBPLocation `json:"location"`
+	Spin     string      `json:"spin"`
+}
+
+// TBPLocation is a piece at its center of rotation
+type TBPLocation struct {
+	Type        string `json:"type"`
+	Orientation string `json:"orientation"`
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+}
+
+// tbpMoveOf returns the TBP move for a placement. The piece is matched
+// by its cells to an SRS one, so any rotation system's pieces convert
+func tbpMoveOf(p *Placement) (TBPMove, error) {
+	cells := p.Piece.Cells()
+	for r := Rotation0; r <= RotationL; r++ {
+		srs := Piece{Type: p.Piece.Type, Rotation: r}
+		dRow, dCol, ok := matchCells(cells, srs.Cells())
+		if !ok {
+			continue
+		}
+		srs.Row, srs.Col = dRow, dCol
+		_, _, x, y, err := fumenPiece(&srs)
+		if err != nil {
+			return TBPMove{}, err
+		}
+		return TBPMove{
+			Location: TBPLocation{Type: p.Piece.Type.String(), Orientation: tbpOrientations[r], X: x, Y: y},
+			Spin:     tbpSpins[p.Spin],
+		}, nil
+	}
+	return TBPMove{}, fmt.Errorf("%s piece's shape isn't a tetromino's", p.Piece.Type)
+}
+
+// piece returns the SRS piece at a location
+func (l TBPLocation) piece() (*Piece, error) {
+	t, ok := tbpPiece(l.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown piece %q", l.Type)
+	}
+	r := slices.Index(tbpOrientations[:], l.Orientation)
+	if r < 0 {
+		return nil, fmt.Errorf("unknown orientation %q", l.Orientation)
+	}
+	return pieceOfFumen(fumenTypes[t], fumenRotations[r], l.X, l.Y)
+}
+
+// tbpPiece returns the tetromino a TBP piece name stands for
+func tbpPiece(name string) (PieceType, bool) {
+	i := slices.IndexFunc(Tetrominoes, func(t PieceType) bool { return t.String() == name })
+	if i < 0 {
+		return 0, false
+	}
+	return Tetrominoes[i], true
+}
+
+// tbpSupports reports whether a bot can play the mode: TBP only knows
+// the tetrominoes at their normal size, and can't tell a bot that hold
+// is off
+func tbpSupports(mode Mode) bool {
+	return !mode.Big && !mode.NoHold && slices.Equal(pieceSet(mode.Pieces), Tetrominoes)
+}
+
+// tbpQueue is the pieces a bot is placing, as it sees them: the board,
+// hold, and the queue starting with the piece in play
+type tbpQueue struct {
+	board   Board
+	queue   []PieceType
+	hold    PieceType
+	hasHold bool
+}
+
+// play locks a piece on the board and takes it from the queue, or from
+// hold if the piece in play was swapped for it. It returns false if
+// neither has the piece, or it doesn't fit
+func (q *tbpQueue) play(p *Piece) bool {
+	switch {
+	case len(q.queue) == 0:
+		return false
+	case q.queue[0] == p.Type:
+		q.queue = q.queue[1:]
+	case q.hasHold && q.hold == p.Type:
+		q.hold, q.queue = q.queue[0], q.queue[1:]
+	case !q.hasHold && len(q.queue) > 1 && q.queue[1] == p.Type:
+		q.hold, q.hasHold, q.queue = q.queue[0], true, q.queue[2:]
+	default:
+		return false
+	}
+	if q.board.Collides(p) || !q.board.Lock(p) {
+		return false
+	}
+	q.board.ClearLines()
+	return true
+}
+
+// start returns the queue as a start message
+func (q *tbpQueue) start() *TBPStart {
+	s := &TBPStart{Board: make([][]*string, tbpRows)}
+	if q.hasHold {
+		hold := q.hold.String()
+		s.Hold = &hold
+	}
+	for _, t := range q.queue {
+		s.Queue = append(s.Queue, t.String())
+	}
+	for y := range s.Board {
+		s.Board[y] = make([]*string, BoardWidth)
+		row := BoardHeight - 1 - y
+		if row < 0 {
+			continue
+		}
+		for col, cell := range q.board.Cells[row] {
+			if !cell.Filled {
+				continue
+			}
+			name := "G"
+			if t, ok := colorPiece(cell.Color); ok {
+				name = t.String()
+			}
+			s.Board[y][col] = &name
+		}
+	}
+	return s
+}
+
+// tbpQueueOf returns the queue a start message gives. Blocks above the
+// top of the board are dropped
+func tbpQueueOf(s *TBPStart) (*tbpQueue, error) {
+	q := &tbpQueue{board: *NewBoard()}
+	if s.Hold != nil {
+		t, ok := tbpPiece(*s.Hold)
+		if !ok {
+			return nil, fmt.Errorf("unknown piece %q", *s.Hold)
+		}
+		q.hold, q.hasHold = t, true
+	}
+	for _, name := range s.Queue {
+		t, ok := tbpPiece(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown piece %q", name)
+		}
+		q.queue = append(q.queue, t)
+	}
+	for y, cells := range s.Board[:min(len(s.Board), BoardHeight)] {
+		for col, cell := range cells[:min(len(cells), BoardWidth)] {
+			if cell == nil {
+				continue
+			}
+			color := ColorGarbage
+			if t, ok := tbpPiece(*cell); ok {
+				color = (&Piece{Type: t}).Color()
+			}
+			q.board.Cells[BoardHeight-1-y][col] = NewFilledCell(color)
+		}
+	}
+	return q, nil
+}
+
+// sameStack reports whether two boards have the same cells filled,
+// whatever their colors
+func sameStack(a, b *Board) bool {
+	for row := range BoardHeight {
+		for col := range BoardWidth {
+			if a.Cells[row][col].Filled != b.Cells[row][col].Filled {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TBPBot is an outside bot, run as a process that speaks TBP. It's a
+// Bot, keeping the bot told of the game's pieces as it's asked for
+// moves, and starting it over from the game's state whenever that's
+// not what the bot expects, such as after garbage rises
+type TBPBot struct {
+	Info TBPInfo
+
+	cmd      *exec.Cmd // The bot's process, nil if it wasn't started by StartTBPBot
+	stdin    io.WriteCloser
+	messages chan tbpMessage // Read from the bot's stdout
+	readErr  error           // Why messages was closed
+	err      error           // First error talking to the bot
+
+	started bool
+	known   tbpQueue // What the bot was last told, if started
+}
+
+// StartTBPBot runs a bot, with its stderr going to stderr, and gives it
+// the rules. The bot is stopped if it doesn't accept them
+func StartTBPBot(command []string, stderr io.Writer) (*TBPBot, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no bot command")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	b := newTBPBot(stdin, stdout)
+	b.cmd = cmd
+	if err := b.handshake(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// newTBPBot creates a bot that is written to on stdin and read from on
+// stdout, before it has introduced itself
+func newTBPBot(stdin io.WriteCloser, stdout io.Reader) *TBPBot {
+	b := &TBPBot{stdin: stdin, messages: make(chan tbpMessage, 16)}
+	go b.read(stdout)
+	return b
+}
+
+// handshake waits for the bot's info, gives it the rules and waits for
+// it to be ready
+func (b *TBPBot) handshake() error {
+	info, err := b.receive("info")
+	if err != nil {
+		return err
+	}
+	b.Info = *info.TBPInfo
+	if err := b.send(tbpMessage{Type: "rules"}); err != nil {
+		return err
+	}
+	_, err = b.receive("ready")
+	return err
+}
+
+// read passes the messages the bot writes to b.messages
+func (b *TBPBot) read(stdout io.Reader) {
+	defer close(b.messages)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var msg tbpMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			b.readErr = fmt.Errorf("bot sent a bad message: %v", err)
+			return
+		}
+		b.messages <- msg
+	}
+	b.readErr = scanner.Err()
+	if b.readErr == nil {
+		b.readErr = errors.New("bot exited")
+	}
+}
+
+// send writes a message to the bot
+func (b *TBPBot) send(msg tbpMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// receive waits for the bot's next message, which must be of the given
+// type. Messages of types TBP doesn't know are skipped
+func (b *TBPBot) receive(want string) (tbpMessage, error) {
+	timeout := time.After(tbpTimeout)
+	for {
+		select {
+		case msg, ok := <-b.messages:
+			switch {
+			case !ok:
+				return msg, b.readErr
+			case msg.Type == "error":
+				return msg, fmt.Errorf("bot error: %s", msg.Reason)
+			case msg.Type == want:
+				if (want == "info" && msg.TBPInfo == nil) || (want == "suggestion" && msg.TBPSuggestion == nil) {
+					return msg, fmt.Errorf("bot sent a %s without its fields", want)
+				}
+				return msg, nil
+			case msg.Type == "info" || msg.Type == "ready" || msg.Type == "suggestion":
+				return msg, fmt.Errorf("bot sent %s, expected %s", msg.Type, want)
+			}
+		case <-timeout:
+			return tbpMessage{}, fmt.Errorf("bot didn't send %s within %s", want, tbpTimeout)
+		}
+	}
+}
+
+// Err returns the first error talking to the bot, after which it makes
+// no more moves
+func (b *TBPBot) Err() error {
+	return b.err
+}
+
+// Choose asks the bot for its moves for the game's current piece and
+// returns the first the piece can reach, see Bot
+func (b *TBPBot) Choose(g *Game) (AIMove, bool) {
+	if b.err != nil || g.Current == nil {
+		return AIMove{}, false
+	}
+	move, ok, err := b.choose(g)
+	if err != nil {
+		b.err = err
+	}
+	return move, ok
+}
+
+// choose brings the bot up to date with the game and asks for a move
+func (b *TBPBot) choose(g *Game) (AIMove, bool, error) {
+	if err := b.sync(g); err != nil {
+		return AIMove{}, false, err
+	}
+	if err := b.send(tbpMessage{Type: "suggest"}); err != nil {
+		return AIMove{}, false, err
+	}
+	msg, err := b.receive("suggestion")
+	if err != nil {
+		return AIMove{}, false, err
+	}
+	for _, suggested := range msg.Moves {
+		move, ok := b.reach(g, suggested)
+		if !ok {
+			continue
+		}
+		tbpMove, err := tbpMoveOf(&move.Placement)
+		if err != nil {
+			continue
+		}
+		if err := b.send(tbpMessage{Type: "play", Move: &tbpMove}); err != nil {
+			return AIMove{}, false, err
+		}
+		b.known.play(&move.Placement.Piece)
+		return move, true, nil
+	}
+	// Nothing the bot wants can be reached, so the game goes its own
+	// way and the bot is started over next time
+	return AIMove{}, false, nil
+}
+
+// sync tells the bot the preview pieces it hasn't seen, or if the game
+// isn't where the bot thinks it is, starts the bot over from the game
+func (b *TBPBot) sync(g *Game) error {
+	game := tbpQueue{
+		board:   *g.Board,
+		queue:   append([]PieceType{g.Current.Type}, g.Queue...),
+		hold:    g.Hold,
+		hasHold: g.HasHold,
+	}
+	known := &b.known
+	if b.started && sameStack(&known.board, &game.board) &&
+		known.hasHold == game.hasHold && (!game.hasHold || known.hold == game.hold) &&
+		len(known.queue) <= len(game.queue) && slices.Equal(known.queue, game.queue[:len(known.queue)]) {
+		for _, t := range game.queue[len(known.queue):] {
+			if err := b.send(tbpMessage{Type: "new_piece", Piece: t.String()}); err != nil {
+				return err
+			}
+		}
+		known.queue = game.queue
+		return nil
+	}
+
+	if b.started {
+		if err := b.send(tbpMessage{Type: "stop"}); err != nil {
+			return err
+		}
+	}
+	if err := b.send(tbpMessage{Type: "start", TBPStart: game.start()}); err != nil {
+		return err
+	}
+	b.started, b.known = true, game
+	return nil
+}
+
+// reach returns the move that puts the game's current piece, or the
+// one hold swaps in, where the bot suggests, if it can get there. The
+// piece is matched by its cells, so any rotation system can be played
+func (b *TBPBot) reach(g *Game, move TBPMove) (AIMove, bool) {
+	target, err := move.Location.piece()
+	if err != nil {
+		return AIMove{}, false
+	}
+	result := AIMove{Hold: target.Type != g.Current.Type}
+	start := g.Current
+	if result.Hold {
+		if g.HoldUsed || g.Mode.NoHold {
+			return AIMove{}, false
+		}
+		swapped := g.Hold
+		if !g.HasHold {
+			if len(g.Queue) == 0 {
+				return AIMove{}, false
+			}
+			swapped = g.Queue[0]
+		}
+		if swapped != target.Type {
+			return AIMove{}, false
+		}
+		start = g.Mode.spawnPiece(swapped)
+	}
+
+	// The same cells may be reached with another spin than the bot's,
+	// which is only taken if its spin can't be had
+	key, spin := placementKey(target), slices.Index(tbpSpins[:], move.Spin)
+	found := false
+	searchPlacements(g.Board, start, g.Mode.rotates180(), func(p Placement) bool {
+		if placementKey(&p.Piece) != key {
+			return true
+		}
+		if !found || int(p.Spin) == spin {
+			result.Placement, found = p, true
+		}
+		return int(p.Spin) != spin
+	})
+	return result, found
+}
+
+// Close asks the bot to quit, and stops its process if it doesn't
+func (b *TBPBot) Close() error {
+	b.send(tbpMessage{Type: "quit"})
+	b.stdin.Close()
+	if b.cmd == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- b.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		b.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// ServeTBP runs an AI as a TBP bot, reading messages from in and
+// writing them to out until it's told to quit or in ends. It stands in
+// for an outside bot, so gotetris can play itself through TBP
+func ServeTBP(ai *AI, in io.Reader, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	info := &TBPInfo{Name: "gotetris", Version: "1", Author: "gotetris", Features: []string{}}
+	if err := enc.Encode(tbpMessage{Type: "info", TBPInfo: info}); err != nil {
+		return err
+	}
+
+	// The bot plays SRS, which has no 180° turns
+	mode := NewEndlessMode(1)
+
+	var state *tbpQueue
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var msg tbpMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("bad message: %v", err)
+		}
+		var reply *tbpMessage
+		switch msg.Type {
+		case "rules":
+			reply = &tbpMessage{Type: "ready"}
+		case "start":
+			if msg.TBPStart == nil {
+				return errors.New("start without a state")
+			}
+			q, err := tbpQueueOf(msg.TBPStart)
+			if err != nil {
+				return err
+			}
+			state = q
+		case "stop":
+			state = nil
+		case "suggest":
+			moves := []TBPMove{}
+			if state != nil {
+				if move, ok := suggestTBP(ai, mode, state); ok {
+					moves = append(moves, move)
+				}
+			}
+			reply = &tbpMessage{Type: "suggestion", TBPSuggestion: &TBPSuggestion{Moves: moves}}
+		case "play":
+			if state == nil || msg.Move == nil {
+				break
+			}
+			p, err := msg.Move.Location.piece()
+			if err != nil {
+				return err
+			}
+			if !state.play(p) {
+				return fmt.Errorf("can't play %s", p.Type)
+			}
+		case "new_piece":
+			if t, ok := tbpPiece(msg.Piece); ok && state != nil {
+				state.queue = append(state.queue, t)
+			}
+		case "quit":
+			return nil
+		}
+		if reply != nil {
+			if err := enc.Encode(reply); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// suggestTBP returns the AI's move for a bot's state, in a game set up
+// to hold it whose preview is exactly the bot's queue
+func suggestTBP(ai *AI, mode Mode, state *tbpQueue) (TBPMove, bool) {
+	if len(state.queue) == 0 {
+		return TBPMove{}, false
+	}
+	board := state.board
+	g := NewGameAt(mode, 1, Position{Board: &board, Queue: state.queue, Hold: state.hold, HasHold: state.hasHold})
+	g.Queue = slices.Clone(state.queue[1:])
+	move, ok := ai.Choose(g)
+	if !ok {
+		return TBPMove{}, false
+	}
+	tbpMove, err := tbpMoveOf(&move.Placement)
+	return tbpMove, err == nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"slices"
+	"testing"
+)
+
+// sentLog is a bot's stdin that notes the type of every message sent
+type sentLog struct {
+	io.WriteCloser
+	types []string
+}
+
+func (l *sentLog) Write(p []byte) (int, error) {
+	var msg tbpMessage
+	if json.Unmarshal(p, &msg) == nil {
+		l.types = append(l.types, msg.Type)
+	}
+	return l.WriteCloser.Write(p)
+}
+
+// count returns how many messages of a type were sent
+func (l *sentLog) count(msgType string) int {
+	n := 0
+	for _, t := range l.types {
+		if t == msgType {
+			n++
+		}
+	}
+	return n
+}
+
+// connectBot connects a TBPBot over pipes to serve, which stands in for
+// a bot process reading its stdin and writing its stdout
+func connectBot(t *testing.T, serve func(in io.Reader, out io.Writer) error) (*TBPBot, *sentLog) {
+	t.Helper()
+	botIn, gameOut := io.Pipe()
+	gameIn, botOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := serve(botIn, botOut)
+		botOut.CloseWithError(err)
+		done <- err
+	}()
+
+	sent := &sentLog{WriteCloser: gameOut}
+	b := newTBPBot(sent, gameIn)
+	if err := b.handshake(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Close()
+		if err := <-done; err != nil {
+			t.Errorf("bot: %v", err)
+		}
+	})
+	return b, sent
+}
+
+// servedAI connects a TBPBot to the built-in AI served with ServeTBP
+func servedAI(t *testing.T) (*TBPBot, *sentLog) {
+	t.Helper()
+	return connectBot(t, func(in io.Reader, out io.Writer) error {
+		return ServeTBP(NewAI(DefaultAIWeights()), in, out)
+	})
+}
+
+// scriptedBot connects a TBPBot to a bot that answers every suggest
+// with the same moves
+func scriptedBot(t *testing.T, moves ...TBPMove) (*TBPBot, *sentLog) {
+	t.Helper()
+	return connectBot(t, func(in io.Reader, out io.Writer) error {
+		enc := json.NewEncoder(out)
+		if err := enc.Encode(tbpMessage{Type: "info", TBPInfo: &TBPInfo{Name: "scripted", Features: []string{}}}); err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			var msg tbpMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				return err
+			}
+			var err error
+			switch msg.Type {
+			case "rules":
+				err = enc.Encode(tbpMessage{Type: "ready"})
+			case "suggest":
+				err = enc.Encode(tbpMessage{Type: "suggestion", TBPSuggestion: &TBPSuggestion{Moves: moves}})
+			case "quit":
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// filledCells counts the filled cells on a board
+func filledCells(b *Board) int {
+	n := 0
+	for _, row := range b.Cells {
+		for _, cell := range row {
+			if cell.Filled {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// countingBot is a Bot that counts the pieces it had no move for
+type countingBot struct {
+	Bot
+	misses int
+}
+
+func (b *countingBot) Choose(g *Game) (AIMove, bool) {
+	move, ok := b.Bot.Choose(g)
+	if !ok {
+		b.misses++
+	}
+	return move, ok
+}
+
+// playPieces plays pieces of a game with a bot, a second of frames
+// apart, failing the test if the bot ever has no move
+func playPieces(t *testing.T, g *Game, bot Bot, pieces int) {
+	t.Helper()
+	counted := &countingBot{Bot: bot}
+	player := NewAIPlayer(counted)
+	for g.Pieces < pieces && !g.Over() {
+		player.PlayPiece(g)
+		for range FramesPerSecond {
+			g.Step()
+		}
+	}
+	if g.Over() {
+		t.Fatalf("game over after %d pieces", g.Pieces)
+	}
+	if counted.misses > 0 {
+		t.Errorf("bot had no move for %d pieces", counted.misses)
+	}
+}
+
+func TestTBPBotPlays(t *testing.T) {
+	pos, err := ParsePosition(`
+		hold: I
+		queue: TSZ
+		_____#____
+		####_#####
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pieces = 40
+	bot, _ := servedAI(t)
+	g := NewGameAt(NewMarathonMode(1), 3, pos)
+	start := filledCells(g.Board)
+	playPieces(t, g, bot, pieces)
+	if err := bot.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every piece locked whole, as four cells, less the cleared rows
+	if got, want := filledCells(g.Board), start+4*g.Pieces-BoardWidth*g.Lines; got != want {
+		t.Errorf("%d filled cells after %d pieces and %d lines, want %d", got, g.Pieces, g.Lines, want)
+	}
+	for row, cells := range g.Board.Cells {
+		if !slices.ContainsFunc(cells[:], func(c Cell) bool { return !c.Filled }) {
+			t.Errorf("row %d is full but wasn't cleared", row)
+		}
+	}
+
+	// Through TBP the AI plays as it does built in
+	builtIn := NewGameAt(NewMarathonMode(1), 3, pos)
+	playPieces(t, builtIn, NewAI(DefaultAIWeights()), pieces)
+	if g.StateHash() != builtIn.StateHash() {
+		t.Errorf("game through TBP ended as\n%swant:\n%s", FormatBoard(g.Board), FormatBoard(builtIn.Board))
+	}
+}
+
+func TestTBPBotRestartsAfterGarbage(t *testing.T) {
+	bot, sent := servedAI(t)
+	g := NewGame(NewSurvivalMode(DefaultMessiness), 5)
+	playPieces(t, g, bot, 30)
+	if err := bot.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if g.garbageAdded == 0 {
+		t.Fatal("no garbage rose")
+	}
+	// Each rise starts the bot over from the game's stack
+	if starts, stops := sent.count("start"), sent.count("stop"); starts < 2 || stops != starts-1 {
+		t.Errorf("bot was started %d times and stopped %d, want a restart after garbage", starts, stops)
+	}
+	if plays := sent.count("play"); plays != g.Pieces {
+		t.Errorf("bot was told of %d plays, want %d", plays, g.Pieces)
+	}
+}
+
+func TestTBPBotUnreachableSuggestion(t *testing.T) {
+	// A T under the roof, which only an I fits through the gap to
+	pos, err := ParsePosition(`
+		queue: TO
+		#########_
+		__________
+		__________
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	under := TBPMove{Location: TBPLocation{Type: "T", Orientation: "north", X: 2, Y: 0}, Spin: "none"}
+	if p, err := under.Location.piece(); err != nil || pos.Board.Collides(p) {
+		t.Fatalf("suggestion isn't a piece on the board: %v", err)
+	}
+
+	t.Run("only move", func(t *testing.T) {
+		bot, sent := scriptedBot(t, under)
+		g := NewGameAt(NewMarathonMode(1), 1, pos)
+		if _, ok := bot.Choose(g); ok {
+			t.Fatal("unreachable move chosen")
+		}
+		if err := bot.Err(); err != nil {
+			t.Fatalf("bot failed: %v", err)
+		}
+		if sent.count("play") != 0 {
+			t.Error("bot told it played an unreachable move")
+		}
+
+		// The player drops the piece itself, and the bot starts over
+		if !NewAIPlayer(bot).PlayPiece(g) || g.Pieces != 1 {
+			t.Fatal("piece wasn't played without the bot")
+		}
+		bot.Choose(g)
+		if sent.count("stop") != 1 || sent.count("start") != 2 {
+			t.Errorf("bot wasn't started over: sent %v", sent.types)
+		}
+	})
+
+	t.Run("later move", func(t *testing.T) {
+		over := TBPMove{Location: TBPLocation{Type: "T", Orientation: "north", X: 2, Y: 3}, Spin: "none"}
+		bot, sent := scriptedBot(t, under, over)
+		g := NewGameAt(NewMarathonMode(1), 1, pos)
+		move, ok := bot.Choose(g)
+		if !ok {
+			t.Fatal("no move chosen")
+		}
+		want, _ := over.Location.piece()
+		if placementKey(&move.Placement.Piece) != placementKey(want) {
+			t.Errorf("chose %+v, want the reachable move on the roof", move.Placement.Piece)
+		}
+		if sent.count("play") != 1 {
+			t.Error("bot wasn't told of the move")
+		}
+	})
+}
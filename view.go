@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// renderStats renders the Stats panel contents for a game
+func renderStats(g *Game) string {
+	stats := fmt.Sprintf("Score: %d\n", g.Score)
+
+	if g.Mode.Levels == LevelsMaster {
+		// Show the level that ends the current section
+		section := min((g.Level/100+1)*100, MasterMaxLevel)
+		stats += fmt.Sprintf("Level: %d/%d\n", g.Level, section)
+		stats += fmt.Sprintf("Grade: %s\n", g.Grade())
+	} else {
+		stats += fmt.Sprintf("Level: %d\n", g.Level)
+	}
+
+	if g.Mode.Goal != GoalNone {
+		stats += fmt.Sprintf("Goal:  %s\n", goalLabel(g.Mode))
+	}
+	if g.Mode.PieceLimit > 0 {
+		stats += fmt.Sprintf("Piece: %d/%d\n", min(g.Pieces+1, g.Mode.PieceLimit), g.Mode.PieceLimit)
+	}
+	if g.Mode.LineGoal > 0 {
+		stats += fmt.Sprintf("Lines: %d/%d\n", g.Lines, g.Mode.LineGoal)
+	} else {
+		stats += fmt.Sprintf("Lines: %d\n", g.Lines)
+	}
+	if g.Mode.GarbageGoal > 0 {
+		stats += fmt.Sprintf("Dug:   %d/%d\n", g.GarbageCleared, g.Mode.GarbageGoal)
+	}
+	if g.Mode.NoTopOut && g.TopOuts > 0 {
+		stats += fmt.Sprintf("Clears: %d\n", g.TopOuts)
+	}
+	if g.Mode.RiseInterval > 0 {
+		stats += fmt.Sprintf("Rise:  %.1fs\n", float64(g.RiseTimeRemaining())/FramesPerSecond)
+	}
+
+	if g.Mode.TimeLimit > 0 {
+		stats += fmt.Sprintf("Left:  %s\n", formatFrames(g.TimeRemaining()))
+	} else {
+		stats += fmt.Sprintf("Time:  %s\n", formatFrames(g.Frame))
+	}
+	if g.Mode.Trainer {
+		stats += fmt.Sprintf("Keys:  %d/%d\n", g.Keys(), g.TargetKeys)
+		stats += fmt.Sprintf("Done:  %d/%d\n", g.Finesse.Judged-g.Finesse.Faults, g.Finesse.Judged)
+		switch f := g.Finesse; {
+		case f.Judged == 0:
+		case f.LastMissed:
+			stats += dimStyle.Render("Missed target") + "\n"
+		case f.LastKeys > f.LastBest:
+			stats += dimStyle.Render(fmt.Sprintf("%d keys, %d needed", f.LastKeys, f.LastBest)) + "\n"
+		default:
+			stats += dimStyle.Render("Perfect") + "\n"
+		}
+	} else if g.Finesse.Judged > 0 {
+		stats += fmt.Sprintf("Faults: %d\n", g.Finesse.Faults)
+	}
+	if o := g.Mode.Opener; o != nil {
+		stats += fmt.Sprintf("Step:  %d/%d\n", g.OpenerStep, len(o.Steps))
+		if g.Current != nil && g.Target == nil && !g.Over() {
+			stats += dimStyle.Render(fmt.Sprintf("Hold the %s", g.Current.Type)) + "\n"
+		}
+	}
+	if h := g.history; h != nil {
+		stats += fmt.Sprintf("Undo:  %d/%d\n", h.depth, h.depth+h.redoDepth())
+		if branch, branches := h.branch(); branches > 1 {
+			stats += fmt.Sprintf("Branch: %d/%d\n", branch, branches)
+		}
+	}
+
+	if g.Mode.NoHold {
+		return stats
+	}
+	stats += "\n" + titleStyle.Render("Hold") + "\n\n"
+	if g.HasHold {
+		preview := renderPiecePreview(g.Hold, g.Mode.Rotation)
+		if g.HoldUsed {
+			// Greyed out until the next piece locks
+			preview = renderPiecePreviewColor(g.Hold, g.Mode.Rotation, ColorHoldUsed)
+		}
+		stats += preview
+	}
+	return stats
+}
+
+// renderVariants renders the rotation system and variants a mode is
+// played with, for the menu
+func renderVariants(mode Mode) string {
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	variants := fmt.Sprintf("Rotation:  %s\n", rotationName(mode.Rotation))
+	if mode.allowsBig() {
+		variants += fmt.Sprintf("Big:       %s\n", onOff(mode.Big))
+	}
+	variants += fmt.Sprintf("Invisible: %s", onOff(mode.Invisible))
+	return variants
+}
+
+// renderQueue renders the upcoming pieces for the Next panel as they
+// spawn in the named rotation system
+func renderQueue(queue []PieceType, system string) string {
+	previews := make([]string, 0, len(queue))
+	for _, pieceType := range queue {
+		previews = append(previews, renderPiecePreview(pieceType, system))
+	}
+	return strings.Join(previews, "\n\n")
+}
+
+// renderPiecePreview renders a piece in its spawn orientation,
+// trimmed to the rows it occupies
+func renderPiecePreview(pieceType PieceType, system string) string {
+	return renderPiecePreviewColor(pieceType, system, (&Piece{Type: pieceType}).Color())
+}
+
+// renderPiecePreviewColor renders a piece preview in the given color
+func renderPiecePreviewColor(pieceType PieceType, system string, color CellColor) string {
+	shape := pieceRotation(system, pieceType).Shape(pieceType, Rotation0)
+
+	// Find the rows and columns the shape uses so the preview has no
+	// blank lines, whatever the size of the piece
+	minRow, maxRow := shape[0].Row, shape[0].Row
+	minCol, maxCol := shape[0].Col, shape[0].Col
+	for _, offset := range shape {
+		minRow = min(minRow, offset.Row)
+		maxRow = max(maxRow, offset.Row)
+		minCol = min(minCol, offset.Col)
+		maxCol = max(maxCol, offset.Col)
+	}
+
+	style := lipgloss.NewStyle().Foreground(lipgloss.Color(color))
+	lines := make([]string, 0, maxRow-minRow+1)
+	for row := minRow; row <= maxRow; row++ {
+		line := ""
+		for col := minCol; col <= maxCol; col++ {
+			filled := false
+			for _, offset := range shape {
+				if offset.Row == row && offset.Col == col {
+					filled = true
+				}
+			}
+			if filled {
+				line += style.Render("██")
+			} else {
+				line += "  "
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderHighScores renders a high score table, highlighting the entry
+// at index highlight (-1 for none). Tables ranked by time show each
+// entry's time instead of its score
+func renderHighScores(table []HighScore, highlight int, ranking Ranking) string {
+	if len(table) == 0 {
+		return dimStyle.Render("No scores yet")
+	}
+
+	lines := make([]string, 0, len(table))
+	for i, entry := range table {
+		line := fmt.Sprintf("%2d. %7d %3dL", i+1, entry.Score, entry.Lines)
+		if ranking != RankScore {
+			line = fmt.Sprintf("%2d. %12s", i+1, formatFrames(entry.Frames))
+		}
+		if i == highlight {
+			line = highlightStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderMiniBoard renders the filled rows of a board at half height,
+// a character per cell and two rows to a line
+func renderMiniBoard(b *Board) string {
+	top := BoardHeight
+	for row := BoardHeight - 1; row >= 0; row-- {
+		for _, cell := range b.Cells[row] {
+			if cell.Filled {
+				top = row
+			}
+		}
+	}
+	// Pair rows from the bottom up, so an odd row out is on top
+	top -= (BoardHeight - top) % 2
+	lines := make([]string, 0, (BoardHeight-top)/2)
+	for row := top; row < BoardHeight; row += 2 {
+		line := ""
+		for col := range BoardWidth {
+			var upper Cell
+			if row >= 0 {
+				upper = b.Cells[row][col]
+			}
+			lower := b.Cells[row+1][col]
+			style := lipgloss.NewStyle()
+			switch {
+			case upper.Filled && lower.Filled:
+				line += style.Foreground(lipgloss.Color(upper.Color)).Background(lipgloss.Color(lower.Color)).Render("▀")
+			case upper.Filled:
+				line += style.Foreground(lipgloss.Color(upper.Color)).Render("▀")
+			case lower.Filled:
+				line += style.Foreground(lipgloss.Color(lower.Color)).Render("▄")
+			default:
+				line += " "
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// puzzleListRows is how many puzzles the puzzle list shows at once
+const puzzleListRows = 16
+
+// renderPuzzles renders the puzzle list around the puzzle at index
+// highlight, checking off the solved ones
+func renderPuzzles(puzzles []Puzzle, progress PuzzleProgress, highlight int) string {
+	first := max(min(highlight-puzzleListRows/2, len(puzzles)-puzzleListRows), 0)
+	last := min(first+puzzleListRows, len(puzzles))
+	lines := make([]string, 0, last-first)
+	for i := first; i < last; i++ {
+		mark := "  "
+		if _, ok := progress[puzzles[i].ID]; ok {
+			mark = "✓ "
+		}
+		line := mark + puzzles[i].Name
+		if i == highlight {
+			line = highlightStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}